@@ -0,0 +1,156 @@
+package ranger
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultMaxAdaptiveFetch is the default upper bound on the size of a fetch extended by adaptive sizing.
+const DefaultMaxAdaptiveFetch int64 = 16 * 1024 * 1024
+
+// linkModelDecay is the weight given to the existing samples each time a new one is added to the link model.
+const linkModelDecay = 0.9
+
+// linkModel estimates the round-trip time and bandwidth of the link to a source by fitting
+// duration = rtt + bytes/bandwidth to the fetches made over it, giving more weight to recent fetches.
+type linkModel struct {
+	mutex sync.Mutex
+
+	// exponentially-decayed sums for a least-squares fit of seconds against bytes
+	w, sx, sy, sxx, sxy float64
+}
+
+// observe adds a fetch of n bytes that took d to the model.
+func (m *linkModel) observe(n int64, d time.Duration) {
+	x, y := float64(n), d.Seconds()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.w = m.w*linkModelDecay + 1
+	m.sx = m.sx*linkModelDecay + x
+	m.sy = m.sy*linkModelDecay + y
+	m.sxx = m.sxx*linkModelDecay + x*x
+	m.sxy = m.sxy*linkModelDecay + x*y
+}
+
+// estimate returns the estimated round-trip time and bandwidth, in bytes per second, of the link.
+// The bandwidth is zero until fetches of sufficiently different sizes have been observed.
+func (m *linkModel) estimate() (time.Duration, float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.w == 0 {
+		return 0, 0
+	}
+
+	meanX, meanY := m.sx/m.w, m.sy/m.w
+	varX := m.sxx/m.w - meanX*meanX
+	covXY := m.sxy/m.w - meanX*meanY
+	if varX <= 0 || covXY <= 0 {
+		// Either every fetch was the same size, or larger fetches weren't slower: all we can see is latency.
+		return time.Duration(meanY * float64(time.Second)), 0
+	}
+
+	secondsPerByte := covXY / varX
+	rtt := meanY - secondsPerByte*meanX
+	if rtt < 0 {
+		rtt = 0
+	}
+	return time.Duration(rtt * float64(time.Second)), 1 / secondsPerByte
+}
+
+// sequenceDetector detects sequential access in one stream of reads, such as those made through a Cursor or Section.
+type sequenceDetector struct {
+	mutex sync.Mutex
+	next  int64 // end of the last read
+}
+
+// sequential records a read of n bytes at off, and reports whether it continued on from the previous one.
+func (s *sequenceDetector) sequential(off, n int64) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	seq := off == s.next
+	s.next = off + n
+	return seq
+}
+
+// LinkEstimate returns the round-trip time and bandwidth, in bytes per second, of the link to the ranged-over source
+// as measured from the Reader's fetches so far. Either may be zero if too few fetches have been made to measure it.
+func (r *Reader) LinkEstimate() (time.Duration, float64) {
+	return r.link.estimate()
+}
+
+// adaptiveFetchSize returns the number of bytes to fetch, from off, for a read of n bytes at off made in the stream
+// tracked by seq, and whether the read continued that stream. It follows the adaptive sizing policy: sequential reads
+// are extended to the link's bandwidth-delay product, the amount of data that can be transferred in the time a request
+// takes to make, while scattered reads fetch only what they need.
+func (r *Reader) adaptiveFetchSize(seq *sequenceDetector, off int64, n int) (int64, bool) {
+	if !seq.sequential(off, int64(n)) {
+		return int64(n), false
+	}
+
+	rtt, bandwidth := r.link.estimate()
+	extent := int64(rtt.Seconds() * bandwidth)
+
+	max := r.MaxAdaptiveFetch
+	if max <= 0 {
+		max = DefaultMaxAdaptiveFetch
+	}
+	if extent > max {
+		extent = max
+	}
+
+	if extent < int64(n) {
+		return int64(n), true
+	}
+	return extent, true
+}
+
+// readAtScattered reads len(p) bytes at off, for a scattered read, by fetching exactly those bytes straight into p
+// without caching them. It does so only if fetching the whole blocks that p lies in would transfer more surplus bytes
+// than the link's bandwidth-delay product, taking longer than a further request for them would if they were ever
+// needed; otherwise, or if any of those blocks is cached or being fetched, it reports false, having read nothing.
+// invariant: after init(); not in extent mode; p lies within the source
+func (r *Reader) readAtScattered(ctx context.Context, p []byte, off int64, st statSinks) (int, bool, error) {
+	startBlock, nblocks := blockRange(off, len(p), r.BlockSize)
+	first, last := r.blockByteRange(startBlock), r.blockByteRange(startBlock+nblocks-1)
+	surplus := last.End - first.Start + 1 - int64(len(p))
+
+	rtt, bandwidth := r.link.estimate()
+	if bandwidth == 0 || float64(surplus) <= rtt.Seconds()*bandwidth {
+		return 0, false, nil
+	}
+	for bn := startBlock; bn < startBlock+nblocks; bn++ {
+		if r.Cache.Has(bn) || r.inflight.lookup(bn) != nil {
+			return 0, false, nil
+		}
+	}
+	st.lookup(0, nblocks)
+
+	fctx, done, err := r.scheduleFetch(ctx)
+	if err != nil {
+		return 0, true, err
+	}
+	var start time.Time
+	ranges := []ByteRange{{off, off + int64(len(p)) - 1}}
+	err = r.Retry.do(fctx, func() error {
+		start = time.Now()
+		return fetchRangesInto(fctx, r.fetcher, ranges, [][]byte{p})
+	})
+	done()
+	if err != nil {
+		return 0, true, err
+	}
+	r.link.observe(int64(len(p)), time.Since(start))
+	st.fetch(int64(len(p)))
+
+	st.read(len(p))
+	if off+int64(len(p)) == r.len {
+		err = io.EOF
+	}
+	return len(p), true, err
+}
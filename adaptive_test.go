@@ -0,0 +1,175 @@
+package ranger
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLinkModelEstimate(t *testing.T) {
+	var m linkModel
+	if rtt, bw := m.estimate(); rtt != 0 || bw != 0 {
+		t.Errorf("expected no estimate without samples, got %v and %f", rtt, bw)
+	}
+
+	rtt, bandwidth := 50*time.Millisecond, 1e6
+	for i := 0; i < 20; i++ {
+		n := int64(16384 << uint(i%4))
+		m.observe(n, rtt+time.Duration(float64(n)/bandwidth*float64(time.Second)))
+	}
+
+	gotRTT, gotBandwidth := m.estimate()
+	if d := gotRTT - rtt; d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("expected round-trip time near %v, got %v", rtt, gotRTT)
+	}
+	if math.Abs(gotBandwidth-bandwidth)/bandwidth > 0.01 {
+		t.Errorf("expected bandwidth near %f, got %f", bandwidth, gotBandwidth)
+	}
+}
+
+// seedLinkModel models a link that moves 1600 bytes in one round trip, replacing the measurements of r's fetches.
+func seedLinkModel(r *Reader) {
+	r.link.w, r.link.sx, r.link.sy, r.link.sxx, r.link.sxy = 0, 0, 0, 0, 0
+	for i := 0; i < 8; i++ {
+		n := int64(1600 << uint(i%2))
+		r.link.observe(n, 10*time.Millisecond+time.Duration(n)*time.Millisecond/160)
+	}
+}
+
+func TestAdaptiveExtent(t *testing.T) {
+	f := newMemoryFetcher(256 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, Adaptive: true}
+	if err := r.init(); err != nil {
+		t.Fatal(err)
+	}
+	seed := func() { seedLinkModel(r) }
+
+	b := make([]byte, 16)
+	seed()
+	r.ReadAt(b, 0)
+	if _, n := f.counts(); n != 100 {
+		t.Errorf("expected a sequential read to fetch 100 blocks, fetched %d", n)
+	}
+
+	seed()
+	r.ReadAt(b, 200*16)
+	if _, n := f.counts(); n != 101 {
+		t.Errorf("expected a scattered read to fetch a single block, fetched %d", n-100)
+	}
+
+	seed()
+	r.MaxAdaptiveFetch = 32
+	r.ReadAt(b, 201*16)
+	if _, n := f.counts(); n != 103 {
+		t.Errorf("expected a capped sequential read to fetch two blocks, fetched %d", n-101)
+	}
+}
+
+func TestAdaptiveInterleavedStreams(t *testing.T) {
+	f := newMemoryFetcher(256 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, Adaptive: true, MaxAdaptiveFetch: 32}
+	if err := r.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	c1, c2 := r.NewCursor(), r.NewCursor()
+	c2.Seek(128*16, 0)
+
+	// Each Cursor reads sequentially, in turn; each of their reads after c2's first should be extended by a block.
+	b := make([]byte, 16)
+	expected := []int{2, 3, 4, 6, 7, 8}
+	for i, c := range []*Cursor{c1, c2, c1, c2, c1, c2} {
+		seedLinkModel(r)
+		if _, err := c.Read(b); err != nil {
+			t.Fatal(err)
+		}
+		if _, n := f.counts(); n != expected[i] {
+			t.Errorf("after read %d, expected %d blocks to have been fetched, got %d", i, expected[i], n)
+		}
+	}
+}
+
+func TestAdaptiveScatteredReads(t *testing.T) {
+	subtest(t, "Blocks", func(t *testing.T) {
+		f := newMemoryFetcher(64 * 4096)
+		r := &Reader{Fetcher: f, BlockSize: 4096, Adaptive: true}
+		if err := r.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		// A whole block would carry far more surplus than the link moves in a round trip: fetch only the read.
+		b := make([]byte, 16)
+		seedLinkModel(r)
+		if _, err := r.ReadAt(b, 10*4096+100); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, f.data[10*4096+100:10*4096+116]) {
+			t.Error("data mismatch")
+		}
+		if rng := f.ranges[len(f.ranges)-1]; rng != (ByteRange{10*4096 + 100, 10*4096 + 115}) {
+			t.Errorf("expected a scattered read to fetch only its own bytes, fetched %v", rng)
+		}
+		if r.Cache.Has(10) {
+			t.Error("expected a partial fetch not to be cached")
+		}
+
+		// Cached blocks are still read from the cache.
+		r.Cache.Put(20, append([]byte(nil), f.data[20*4096:21*4096]...))
+		_, before := f.counts()
+		seedLinkModel(r)
+		if _, err := r.ReadAt(b, 20*4096+100); err != nil {
+			t.Fatal(err)
+		}
+		if _, after := f.counts(); after != before {
+			t.Errorf("expected a read of a cached block to fetch nothing, fetched %d ranges", after-before)
+		}
+		if !bytes.Equal(b, f.data[20*4096+100:20*4096+116]) {
+			t.Error("data mismatch")
+		}
+	})
+
+	subtest(t, "CheapBlocksAreCached", func(t *testing.T) {
+		f := newMemoryFetcher(64 * 256)
+		r := &Reader{Fetcher: f, BlockSize: 256, Adaptive: true}
+		if err := r.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The link moves 1600 bytes in a round trip; the rest of a 256-byte block costs next to nothing.
+		b := make([]byte, 16)
+		seedLinkModel(r)
+		if _, err := r.ReadAt(b, 10*256+100); err != nil {
+			t.Fatal(err)
+		}
+		if !r.Cache.Has(10) {
+			t.Error("expected the whole block to be fetched and cached")
+		}
+	})
+
+	subtest(t, "Extents", func(t *testing.T) {
+		f := newMemoryFetcher(64 * 4096)
+		r := &Reader{Fetcher: f, BlockSize: 4096, Adaptive: true, Extents: true, MinFetchSize: 1}
+		if err := r.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		b := make([]byte, 16)
+		seedLinkModel(r)
+		if _, err := r.ReadAt(b, 10*4096+100); err != nil {
+			t.Fatal(err)
+		}
+		if rng := f.ranges[len(f.ranges)-1]; rng != (ByteRange{10*4096 + 100, 10*4096 + 115}) {
+			t.Errorf("expected a scattered read to fetch only its own bytes, fetched %v", rng)
+		}
+
+		// The next read continues on from it, and is extended to the bandwidth-delay product.
+		seedLinkModel(r)
+		if _, err := r.ReadAt(b, 10*4096+116); err != nil {
+			t.Fatal(err)
+		}
+		if rng := f.ranges[len(f.ranges)-1]; rng != (ByteRange{10*4096 + 116, 10*4096 + 116 + 1600 - 1}) {
+			t.Errorf("expected a sequential read to be extended to 1600 bytes, fetched %v", rng)
+		}
+	})
+}
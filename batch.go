@@ -0,0 +1,62 @@
+package ranger
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// batcher collects the blocks claimed by concurrent reads over a short window, so that they can be fetched together.
+type batcher struct {
+	mutex     sync.Mutex
+	blocks    []int
+	sinks     map[*statCounters]struct{}
+	scheduled bool
+}
+
+// submitBatch adds blocks, which the caller has claimed, to the next batch, which is fetched r.BatchWindow after
+// the first blocks were added to it. Callers wait for the blocks as they would for any other being fetched.
+func (r *Reader) submitBatch(claimed []int, st statSinks) {
+	b := &r.batch
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.blocks = append(b.blocks, claimed...)
+	if b.sinks == nil {
+		b.sinks = make(map[*statCounters]struct{})
+	}
+	for _, s := range st {
+		b.sinks[s] = struct{}{}
+	}
+
+	if !b.scheduled {
+		b.scheduled = true
+		time.AfterFunc(r.BatchWindow, r.flushBatch)
+	}
+}
+
+// flushBatch fetches the blocks collected by submitBatch, along with the gaps between them that r.Coalesce would fill,
+// in a single request.
+func (r *Reader) flushBatch() {
+	b := &r.batch
+	b.mutex.Lock()
+	blocks := b.blocks
+	st := make(statSinks, 0, len(b.sinks))
+	for s := range b.sinks {
+		st = append(st, s)
+	}
+	b.blocks, b.sinks, b.scheduled = nil, nil, false
+	b.mutex.Unlock()
+
+	sort.Ints(blocks)
+	if r.Coalesce != nil && len(blocks) > 1 {
+		gaps, _, _ := r.inflight.claim(r.Cache, r.Coalesce.gapBlocks(blocks, r.BlockSize), nil)
+		blocks = append(blocks, gaps...)
+		sort.Ints(blocks)
+	}
+
+	// The fetch serves every read in the batch, so none of them can cancel it.
+	ctx := context.Background()
+	_ = r.fetchClaimedBlocks(ctx, blocks, r.newFetchTicket(ctx), func(int, []byte) {}, st)
+}
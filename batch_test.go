@@ -0,0 +1,191 @@
+package ranger
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamingFetcher is a memoryFetcher that delivers the first range it is asked for at once, and the rest only once release is closed.
+type streamingFetcher struct {
+	*memoryFetcher
+	release chan struct{}
+}
+
+func (s *streamingFetcher) FetchRangesContext(ctx context.Context, ranges []ByteRange) ([]Block, error) {
+	<-s.release
+	return s.memoryFetcher.FetchRanges(ranges)
+}
+
+func (s *streamingFetcher) FetchRangesStreaming(ctx context.Context, ranges []ByteRange, deliver func(i int, b Block)) error {
+	blox, err := s.memoryFetcher.FetchRanges(ranges)
+	if err != nil {
+		return err
+	}
+	for i, b := range blox {
+		if i == 1 {
+			<-s.release
+		}
+		deliver(i, b)
+	}
+	return nil
+}
+
+// readConcurrently reads 8 bytes at each of offs at once, and checks what it reads against data.
+func readConcurrently(t *testing.T, r *Reader, data []byte, offs ...int64) {
+	var wg sync.WaitGroup
+	for _, off := range offs {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			b := make([]byte, 8)
+			if _, err := r.ReadAt(b, off); err != nil {
+				t.Error(err)
+			}
+			if !bytes.Equal(b, data[off:off+8]) {
+				t.Errorf("data mismatch at %d", off)
+			}
+		}(off)
+	}
+	wg.Wait()
+}
+
+func TestBatchWindow(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, BatchWindow: 50 * time.Millisecond}
+
+	readConcurrently(t, r, f.data, 0, 64, 128, 192, 256, 320, 384, 448)
+	if calls, nranges := f.counts(); calls != 1 || nranges != 8 {
+		t.Errorf("expected one fetch of 8 blocks, made %d of %d", calls, nranges)
+	}
+
+	// The gaps between the blocks of a batch are filled according to the Coalesce policy.
+	f = newMemoryFetcher(64 * 16)
+	r = &Reader{Fetcher: f, BlockSize: 16, BatchWindow: 50 * time.Millisecond, Coalesce: &CoalescePolicy{RangeOverhead: 16, GapByteCost: 1}}
+	readConcurrently(t, r, f.data, 0, 32, 512)
+	if calls, nranges := f.counts(); calls != 1 || nranges != 4 {
+		t.Errorf("expected one fetch of 4 blocks, made %d of %d", calls, nranges)
+	}
+}
+
+func TestBatchReleasesEachRead(t *testing.T) {
+	f := &streamingFetcher{newMemoryFetcher(64 * 16), make(chan struct{})}
+	r := &Reader{Fetcher: f, BlockSize: 16, BatchWindow: 20 * time.Millisecond}
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := r.ReadAt(make([]byte, 8), 0)
+		first <- err
+	}()
+	second := make(chan error, 1)
+	go func() {
+		_, err := r.ReadAt(make([]byte, 8), 512)
+		second <- err
+	}()
+
+	select {
+	case err := <-first:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the read of the first block to complete before the rest of the batch")
+	}
+
+	close(f.release)
+	if err := <-second; err != nil {
+		t.Fatal(err)
+	}
+	if calls, _ := f.counts(); calls != 1 {
+		t.Errorf("expected one fetch, made %d", calls)
+	}
+}
+
+func TestBatchWindowHTTP(t *testing.T) {
+	u, _ := url.Parse(testServer.URL + "/blocks/bl3")
+	want := make([]byte, 1024*64)
+	plain, _ := newReaderBlockSize(u, 1024)
+	plain.ReadAt(want, 0)
+
+	r := &Reader{Fetcher: &HTTPRanger{URL: u}, BlockSize: 1024, BatchWindow: 20 * time.Millisecond}
+	readConcurrently(t, r, want, 0, 5000, 9000, 20000, 40000, 63000)
+}
+
+// shortFetcher is a memoryFetcher whose first short fetches each return every block but the last one byte short,
+// or, if dropLast is set, leave the last block out altogether.
+type shortFetcher struct {
+	*memoryFetcher
+	short    int
+	dropLast bool
+}
+
+func (s *shortFetcher) FetchRanges(ranges []ByteRange) ([]Block, error) {
+	s.memoryFetcher.mutex.Lock()
+	shorten := s.short > 0
+	s.short--
+	s.memoryFetcher.mutex.Unlock()
+
+	blox, err := s.memoryFetcher.FetchRanges(ranges)
+	if err != nil || !shorten {
+		return blox, err
+	}
+	if s.dropLast {
+		return blox[:len(blox)-1], nil
+	}
+	last := &blox[len(blox)-1]
+	last.Data = last.Data[:len(last.Data)-1]
+	return blox, nil
+}
+
+func TestShortFetch(t *testing.T) {
+	for _, dropLast := range []bool{false, true} {
+		name := "ShortBlock"
+		if dropLast {
+			name = "MissingBlock"
+		}
+
+		subtest(t, name, func(t *testing.T) {
+			f := &shortFetcher{memoryFetcher: newMemoryFetcher(64 * 16), short: 2, dropLast: dropLast}
+			r := &Reader{Fetcher: f, BlockSize: 16}
+
+			if err := r.Prefetch([]ByteRange{{0, 47}}); err != errShortFetch {
+				t.Errorf("prefetch: expected %v, got %v", errShortFetch, err)
+			}
+			if r.Cache.Has(2) {
+				t.Error("expected the short block not to have been cached")
+			}
+			if _, err := r.ReadAt(make([]byte, 16), 32); err != errShortFetch {
+				t.Errorf("read: expected %v, got %v", errShortFetch, err)
+			}
+
+			// Once the fetcher recovers, the block can be read.
+			b := make([]byte, 16)
+			if _, err := r.ReadAt(b, 32); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, f.data[32:48]) {
+				t.Error("data mismatch")
+			}
+		})
+
+		subtest(t, name+"/Retried", func(t *testing.T) {
+			f := &shortFetcher{memoryFetcher: newMemoryFetcher(64 * 16), short: 1, dropLast: dropLast}
+			r := &Reader{Fetcher: f, BlockSize: 16, Retry: &RetryPolicy{BaseDelay: time.Millisecond}}
+
+			b := make([]byte, 48)
+			if _, err := r.ReadAt(b, 0); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, f.data[0:48]) {
+				t.Error("data mismatch")
+			}
+			// The retry fetched only the block that the first attempt came up short on.
+			if _, n := f.counts(); n != 4 {
+				t.Errorf("expected 4 ranges fetched, got %d", n)
+			}
+		})
+	}
+}
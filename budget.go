@@ -0,0 +1,187 @@
+package ranger
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Budget is a limit on the memory used for block data, shared by any number of Readers and MemoryCaches.
+//
+// The blocks held by every MemoryCache attached to a Budget count against its limit, as do the blocks being fetched
+// by every Reader attached to it. Once the limit is exceeded, the least-recently-used unpinned blocks across all of
+// the caches are evicted. While there is no room left to evict, because the remaining blocks are pinned or still being
+// fetched, further fetches wait for some to be made; one fetch is always allowed to proceed, so that a single fetch
+// larger than the limit cannot stall forever.
+type Budget struct {
+	limit int64
+
+	mutex    sync.Mutex
+	used     int64      // bytes of cached blocks and outstanding reservations
+	reserved int64      // bytes of outstanding reservations
+	lru      *list.List // of *budgetEntry; most recently used at the front
+	entries  map[budgetKey]*list.Element
+	changed  chan struct{} // closed, and replaced, whenever room may have been made
+}
+
+type budgetKey struct {
+	cache *MemoryCache
+	index int
+}
+
+type budgetEntry struct {
+	key  budgetKey
+	size int64
+}
+
+// NewBudget returns a Budget limiting block data to limit bytes.
+func NewBudget(limit int64) *Budget {
+	return &Budget{
+		limit:   limit,
+		lru:     list.New(),
+		entries: make(map[budgetKey]*list.Element),
+		changed: make(chan struct{}),
+	}
+}
+
+// Limit returns the number of bytes of block data to which the budget is limited.
+func (b *Budget) Limit() int64 {
+	return b.limit
+}
+
+// Used returns the number of bytes of block data currently counted against the budget, both cached and being fetched.
+func (b *Budget) Used() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.used
+}
+
+// invariant: b.mutex is held
+func (b *Budget) wakeLocked() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// wake releases any fetches waiting for room to be made, so that they can try again.
+func (b *Budget) wake() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.wakeLocked()
+}
+
+// charge counts size bytes for block i of c, marking it as the most recently used.
+func (b *Budget) charge(c *MemoryCache, i int, size int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key := budgetKey{c, i}
+	if e, ok := b.entries[key]; ok {
+		ent := e.Value.(*budgetEntry)
+		b.used += size - ent.size
+		ent.size = size
+		b.lru.MoveToFront(e)
+		return
+	}
+	b.entries[key] = b.lru.PushFront(&budgetEntry{key, size})
+	b.used += size
+}
+
+// touch marks block i of c as the most recently used.
+func (b *Budget) touch(c *MemoryCache, i int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if e, ok := b.entries[budgetKey{c, i}]; ok {
+		b.lru.MoveToFront(e)
+	}
+}
+
+// discharge stops counting block i of c.
+func (b *Budget) discharge(c *MemoryCache, i int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key := budgetKey{c, i}
+	if e, ok := b.entries[key]; ok {
+		b.used -= e.Value.(*budgetEntry).size
+		b.lru.Remove(e)
+		delete(b.entries, key)
+		b.wakeLocked()
+	}
+}
+
+// shrink evicts the least-recently-used unpinned blocks, across all caches, until no more than target bytes are counted.
+// It reports whether it evicted anything.
+//
+// The caches must not be locked by the caller: the budget is always locked after a cache, never before.
+func (b *Budget) shrink(target int64) bool {
+	var evicted bool
+	var skip map[budgetKey]bool
+	for {
+		var victim budgetKey
+		found := false
+
+		b.mutex.Lock()
+		if b.used > target {
+			for e := b.lru.Back(); e != nil; e = e.Prev() {
+				if key := e.Value.(*budgetEntry).key; !skip[key] {
+					victim, found = key, true
+					break
+				}
+			}
+		}
+		b.mutex.Unlock()
+
+		if !found {
+			return evicted
+		}
+		if victim.cache.evictBlock(victim.index) {
+			evicted = true
+			continue
+		}
+
+		// pinned, or already gone
+		if skip == nil {
+			skip = make(map[budgetKey]bool)
+		}
+		skip[victim] = true
+	}
+}
+
+// reserve counts n bytes that are about to be fetched against the budget, evicting cached blocks to make room for
+// them and waiting, if there is none to be made, until some is or ctx is done.
+func (b *Budget) reserve(ctx context.Context, n int64) error {
+	for {
+		b.mutex.Lock()
+		if b.used+n <= b.limit || b.reserved == 0 {
+			b.used += n
+			b.reserved += n
+			b.mutex.Unlock()
+
+			b.shrink(b.limit)
+			return nil
+		}
+		changed := b.changed
+		b.mutex.Unlock()
+
+		if b.shrink(b.limit - n) {
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release stops counting n bytes reserved by reserve.
+func (b *Budget) release(n int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.used -= n
+	b.reserved -= n
+	b.wakeLocked()
+}
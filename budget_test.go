@@ -0,0 +1,82 @@
+package ranger
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBudgetEvictsAcrossReaders(t *testing.T) {
+	budget := NewBudget(64)
+	f1, f2 := newMemoryFetcher(64*16), newMemoryFetcher(64*16)
+	r1 := &Reader{Fetcher: f1, BlockSize: 16, Budget: budget}
+	r2 := &Reader{Fetcher: f2, BlockSize: 16, Budget: budget}
+
+	r1.ReadAt(make([]byte, 48), 0)
+	r2.ReadAt(make([]byte, 16), 0)
+	if budget.Used() != 64 {
+		t.Errorf("expected 64 bytes used, got %d", budget.Used())
+	}
+
+	// Touch r1's first block, so that its second is now the least recently used of all.
+	r1.ReadAt(make([]byte, 1), 0)
+	r2.ReadAt(make([]byte, 16), 16)
+
+	if budget.Used() != 64 {
+		t.Errorf("expected 64 bytes used, got %d", budget.Used())
+	}
+	if !r1.Cache.Has(0) || r1.Cache.Has(1) || !r1.Cache.Has(2) {
+		t.Errorf("expected block 1 of the first reader to be evicted, have %v", r1.Cache.Coverage())
+	}
+
+	// Pinned blocks survive any amount of pressure from other readers.
+	r1.Pin(0, 16)
+	r2.ReadAt(make([]byte, 256), 256)
+	if !r1.Cache.Has(0) {
+		t.Error("expected a pinned block to survive eviction")
+	}
+	if r1.Cache.Has(2) {
+		t.Error("expected an unpinned block to be evicted")
+	}
+	if budget.Used() > 64 {
+		t.Errorf("expected no more than 64 bytes used, got %d", budget.Used())
+	}
+}
+
+func TestBudgetBackpressure(t *testing.T) {
+	budget := NewBudget(32)
+	gf := &gatedFetcher{newMemoryFetcher(64 * 16), 0, make(chan struct{})}
+	r1 := &Reader{Fetcher: gf, BlockSize: 16, Budget: budget}
+	r2 := &Reader{Fetcher: newMemoryFetcher(64 * 16), BlockSize: 16, Budget: budget}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r1.ReadAt(make([]byte, 32), 0)
+	}()
+
+	// Wait for r1's fetch to take up the whole budget.
+	for budget.Used() < 32 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := r2.ReadAtContext(ctx, make([]byte, 16), 0); err != context.DeadlineExceeded {
+		t.Errorf("expected a fetch to wait for the exhausted budget, got %v", err)
+	}
+
+	read := make(chan error)
+	go func() {
+		_, err := r2.ReadAt(make([]byte, 16), 0)
+		read <- err
+	}()
+	close(gf.release)
+	<-done
+	if err := <-read; err != nil {
+		t.Fatal(err)
+	}
+	if budget.Used() != 32 {
+		t.Errorf("expected 32 bytes used, got %d", budget.Used())
+	}
+}
@@ -0,0 +1,292 @@
+package ranger
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+)
+
+// BlockCache is the interface implemented by stores of fetched blocks, keyed by block index.
+//
+// Get returns the data for a block and whether it was present.
+//
+// Put stores the data for a block, replacing any existing data.
+//
+// Has reports whether a block is present without otherwise affecting it (for instance, its recency).
+//
+// Delete removes a block, if present.
+//
+// Coverage returns the present blocks as a sorted list of non-overlapping, non-adjacent BlockRanges.
+//
+// Implementations must be safe for concurrent use; a BlockCache may be free to discard any block at any time.
+type BlockCache interface {
+	Get(i int) ([]byte, bool)
+	Put(i int, data []byte)
+	Has(i int) bool
+	Delete(i int)
+	Coverage() []BlockRange
+}
+
+// PinningCache is implemented by BlockCaches that can be asked not to discard specific blocks.
+//
+// Pin prevents block i from being discarded, whether or not it is currently present.
+// Pins nest; each call to Pin must be balanced by a call to Unpin.
+type PinningCache interface {
+	BlockCache
+	Pin(i int)
+	Unpin(i int)
+}
+
+// BlockRange represents an inclusive range of block indices.
+type BlockRange struct {
+	Start, End int
+}
+
+// blockRangesFromIndices coalesces a sorted list of block indices into BlockRanges
+func blockRangesFromIndices(indices []int) []BlockRange {
+	var out []BlockRange
+	for _, i := range indices {
+		if n := len(out); n > 0 && out[n-1].End+1 == i {
+			out[n-1].End = i
+			continue
+		}
+		out = append(out, BlockRange{i, i})
+	}
+	return out
+}
+
+// MemoryCache is an in-memory PinningCache that evicts the least-recently-used
+// blocks once the size of its contents exceeds its limit, or their number exceeds MaxBlocks.
+type MemoryCache struct {
+	// maximum number of blocks to retain, pinned blocks included, before evicting unpinned ones; zero means no limit.
+	// It must be set before the cache is first used.
+	MaxBlocks int
+
+	// the pool to which the buffers of evicted, deleted and replaced blocks are returned, unless they are pinned;
+	// if it is set, the cache takes ownership of the blocks put into it
+	Pool BufferPool
+
+	// the budget, shared with other caches, against which the cache's blocks are counted in addition to its own limit;
+	// it must be set before the cache is first used
+	Budget *Budget
+
+	mutex sync.Mutex
+
+	// maximum number of bytes of block data to retain; <= 0 means no limit
+	limit int64
+
+	size    int64
+	lru     *list.List // of *cacheEntry; most recently used at the front
+	entries map[int]*list.Element
+	pins    map[int]int // block index -> pin count
+}
+
+type cacheEntry struct {
+	index int
+	data  []byte
+}
+
+// NewMemoryCache returns a MemoryCache that retains at most limit bytes of unpinned block data.
+// A limit of zero means that the cache is unbounded.
+func NewMemoryCache(limit int64) *MemoryCache {
+	return &MemoryCache{
+		limit:   limit,
+		lru:     list.New(),
+		entries: make(map[int]*list.Element),
+		pins:    make(map[int]int),
+	}
+}
+
+// Get returns the data for block i, marking it as recently used.
+func (c *MemoryCache) Get(i int) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[i]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	if c.Budget != nil {
+		c.Budget.touch(c, i)
+	}
+	return e.Value.(*cacheEntry).data, true
+}
+
+// Put stores the data for block i and evicts blocks as necessary to bring the cache back under its limit,
+// and its budget back under the budget's limit.
+func (c *MemoryCache) Put(i int, data []byte) {
+	c.put(i, data)
+	if c.Budget != nil {
+		c.Budget.shrink(c.Budget.limit)
+	}
+}
+
+func (c *MemoryCache) put(i int, data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, ok := c.entries[i]; ok {
+		ent := e.Value.(*cacheEntry)
+		c.size += int64(len(data) - len(ent.data))
+		if len(ent.data) > 0 && (len(data) == 0 || &ent.data[0] != &data[0]) {
+			c.recycle(i, ent.data)
+		}
+		ent.data = data
+		c.lru.MoveToFront(e)
+	} else {
+		c.entries[i] = c.lru.PushFront(&cacheEntry{index: i, data: data})
+		c.size += int64(len(data))
+	}
+	if c.Budget != nil {
+		c.Budget.charge(c, i, int64(len(data)))
+	}
+	c.evict()
+}
+
+// Has reports whether block i is present.
+func (c *MemoryCache) Has(i int) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, ok := c.entries[i]
+	return ok
+}
+
+// Delete removes block i, even if it is pinned.
+func (c *MemoryCache) Delete(i int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, ok := c.entries[i]; ok {
+		c.remove(e)
+		c.recycle(i, e.Value.(*cacheEntry).data)
+	}
+}
+
+// Coverage returns the ranges of blocks present in the cache.
+func (c *MemoryCache) Coverage() []BlockRange {
+	c.mutex.Lock()
+	indices := make([]int, 0, len(c.entries))
+	for i := range c.entries {
+		indices = append(indices, i)
+	}
+	c.mutex.Unlock()
+
+	sort.Ints(indices)
+	return blockRangesFromIndices(indices)
+}
+
+// Size returns the number of bytes of block data currently held.
+func (c *MemoryCache) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
+
+// view calls f with the data for block i, if it is present, without marking it as recently used.
+// f must not retain data or call back into the cache.
+func (c *MemoryCache) view(i int, f func(data []byte)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, ok := c.entries[i]; ok {
+		f(e.Value.(*cacheEntry).data)
+	}
+}
+
+// each calls f with the index and data of every block present, as view does.
+func (c *MemoryCache) each(f func(i int, data []byte)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i, e := range c.entries {
+		f(i, e.Value.(*cacheEntry).data)
+	}
+}
+
+// Pin prevents block i from being evicted, whether or not it is currently cached.
+func (c *MemoryCache) Pin(i int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pins[i]++
+}
+
+// Unpin releases one pin on block i, making it eligible for eviction once no pins remain.
+func (c *MemoryCache) Unpin(i int) {
+	if c.unpin(i) && c.Budget != nil {
+		// Room may now be made for fetches waiting on the budget.
+		c.Budget.shrink(c.Budget.limit)
+		c.Budget.wake()
+	}
+}
+
+// unpin releases one pin on block i, and reports whether that was the last.
+func (c *MemoryCache) unpin(i int) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.pins[i] <= 1 {
+		delete(c.pins, i)
+		c.evict()
+		return true
+	}
+	c.pins[i]--
+	return false
+}
+
+// evictBlock removes block i, on behalf of the cache's budget, unless it is pinned. It reports whether it did.
+func (c *MemoryCache) evictBlock(i int) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[i]
+	if !ok || c.pins[i] > 0 {
+		return false
+	}
+	c.remove(e)
+	c.recycle(i, e.Value.(*cacheEntry).data)
+	return true
+}
+
+// invariant: c.mutex is held
+func (c *MemoryCache) remove(e *list.Element) {
+	ent := e.Value.(*cacheEntry)
+	c.lru.Remove(e)
+	delete(c.entries, ent.index)
+	c.size -= int64(len(ent.data))
+	if c.Budget != nil {
+		c.Budget.discharge(c, ent.index)
+	}
+}
+
+// recycle returns the buffer of block i to c.Pool, if there is one and the block is not pinned.
+// invariant: c.mutex is held
+func (c *MemoryCache) recycle(i int, data []byte) {
+	if c.Pool != nil && c.pins[i] == 0 {
+		c.Pool.Put(data)
+	}
+}
+
+// evict removes unpinned blocks, least recently used first, until the cache is within its limits.
+// invariant: c.mutex is held
+func (c *MemoryCache) evict() {
+	if c.limit <= 0 && c.MaxBlocks <= 0 {
+		return
+	}
+
+	for e := c.lru.Back(); e != nil && c.over(); {
+		prev := e.Prev()
+		if ent := e.Value.(*cacheEntry); c.pins[ent.index] == 0 {
+			c.remove(e)
+			c.recycle(ent.index, ent.data)
+		}
+		e = prev
+	}
+}
+
+// over reports whether the cache holds more than either of its limits allows.
+// invariant: c.mutex is held
+func (c *MemoryCache) over() bool {
+	return (c.limit > 0 && c.size > c.limit) || (c.MaxBlocks > 0 && len(c.entries) > c.MaxBlocks)
+}
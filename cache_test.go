@@ -0,0 +1,174 @@
+package ranger
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryCacheEviction(t *testing.T) {
+	c := NewMemoryCache(3 * 16)
+	for i := 0; i < 3; i++ {
+		c.Put(i, make([]byte, 16))
+	}
+
+	// touch block 0 so that block 1 is the least recently used
+	if _, ok := c.Get(0); !ok {
+		t.Fatal("block 0 missing before eviction")
+	}
+
+	c.Put(3, make([]byte, 16))
+	if _, ok := c.Get(1); ok {
+		t.Error("block 1 should have been evicted")
+	}
+	for _, i := range []int{0, 2, 3} {
+		if _, ok := c.Get(i); !ok {
+			t.Errorf("block %d should still be cached", i)
+		}
+	}
+	if c.Size() != 3*16 {
+		t.Errorf("expected cache size %d, got %d", 3*16, c.Size())
+	}
+}
+
+func TestMemoryCacheMaxBlocks(t *testing.T) {
+	c := NewMemoryCache(0)
+	c.MaxBlocks = 2
+	c.Pin(0)
+	for i := 0; i < 4; i++ {
+		c.Put(i, make([]byte, 16))
+	}
+
+	expected := []BlockRange{{0, 0}, {3, 3}}
+	if cov := c.Coverage(); !reflect.DeepEqual(cov, expected) {
+		t.Errorf("expected coverage %v, got %v", expected, cov)
+	}
+}
+
+func TestMemoryCachePinning(t *testing.T) {
+	c := NewMemoryCache(16)
+	c.Pin(0)
+	c.Put(0, make([]byte, 16))
+	c.Put(1, make([]byte, 16))
+	c.Put(2, make([]byte, 16))
+
+	if _, ok := c.Get(0); !ok {
+		t.Fatal("pinned block was evicted")
+	}
+	if _, ok := c.Get(1); ok {
+		t.Error("block 1 should have been evicted")
+	}
+
+	c.Unpin(0)
+	c.Put(3, make([]byte, 16))
+	if _, ok := c.Get(0); ok {
+		t.Error("unpinned block should have been evicted")
+	}
+}
+
+func TestMemoryCacheCoverage(t *testing.T) {
+	c := NewMemoryCache(0)
+	for _, i := range []int{7, 0, 1, 2, 5, 8} {
+		c.Put(i, []byte{byte(i)})
+	}
+	c.Delete(8)
+
+	expected := []BlockRange{{0, 2}, {5, 5}, {7, 7}}
+	if cov := c.Coverage(); !reflect.DeepEqual(cov, expected) {
+		t.Errorf("expected coverage %v, got %v", expected, cov)
+	}
+	if c.Has(8) {
+		t.Error("deleted block still present")
+	}
+}
+
+func TestReaderBoundedCache(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, CacheSize: 4 * 16}
+
+	if err := r.Pin(0, 16); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64*16)
+	if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, f.data) {
+		t.Fatal("data mismatch on read larger than the cache")
+	}
+
+	_, before := f.counts()
+	b := make([]byte, 16)
+	r.ReadAt(b, 0)
+	if _, after := f.counts(); after != before {
+		t.Error("pinned block was fetched again")
+	}
+
+	r.ReadAt(b, 16)
+	if _, after := f.counts(); after != before+1 {
+		t.Error("evicted block was not fetched again")
+	}
+	if !bytes.Equal(b, f.data[16:32]) {
+		t.Error("data mismatch after refetch")
+	}
+}
+
+func TestReaderCacheBlocks(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, CacheBlocks: 4}
+
+	buf := make([]byte, 64*16)
+	if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, f.data) {
+		t.Fatal("data mismatch on read larger than the cache")
+	}
+
+	expected := []BlockRange{{60, 63}}
+	if cov := r.Cache.Coverage(); !reflect.DeepEqual(cov, expected) {
+		t.Errorf("expected coverage %v, got %v", expected, cov)
+	}
+}
+
+func TestReaderCachedRanges(t *testing.T) {
+	f := newMemoryFetcher(10*16 + 8)
+	r := &Reader{Fetcher: f, BlockSize: 16}
+
+	b := make([]byte, 4)
+	r.ReadAt(b, 20)
+	r.ReadAt(b, 10*16+2)
+
+	ranges, err := r.CachedRanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ByteRange{{16, 31}, {160, 167}}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("expected cached ranges %v, got %v", expected, ranges)
+	}
+}
+
+func TestReaderIgnoresMisshapenCachedBlocks(t *testing.T) {
+	f := newMemoryFetcher(4*16 + 5)
+	c := NewMemoryCache(0)
+	r := &Reader{Fetcher: f, BlockSize: 16, Cache: c}
+
+	// A block that is too short, one that is too long, and a final block that should be short but isn't
+	c.Put(1, make([]byte, 3))
+	c.Put(2, make([]byte, 20))
+	c.Put(4, make([]byte, 16))
+
+	b := make([]byte, len(f.data))
+	if _, err := r.ReadAt(b, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, f.data) {
+		t.Error("data mismatch")
+	}
+	if data, _ := c.Get(1); len(data) != 16 {
+		t.Errorf("expected the misshapen block to have been replaced, got %d bytes", len(data))
+	}
+}
@@ -0,0 +1,212 @@
+package ranger
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// stored block encodings, recorded in the first byte of each stored block
+const (
+	blockStoredRaw   byte = 0
+	blockStoredFlate byte = 1
+)
+
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+var flateReaders = sync.Pool{
+	New: func() interface{} {
+		return flate.NewReader(nil)
+	},
+}
+
+// CompressionStats describes the blocks held by a CompressedCache, and whether compressing them is paying off.
+type CompressionStats struct {
+	Blocks         int64 // number of blocks held
+	RawBytes       int64 // total uncompressed size of the blocks held
+	StoredBytes    int64 // number of bytes the blocks held occupy
+	Incompressible int64 // number of blocks held uncompressed, because compressing them did not make them any smaller
+
+	Decompressions int64 // number of times any block has been decompressed to be read
+}
+
+// Ratio returns the ratio of the raw size of the blocks held to the memory they occupy; greater is better.
+func (s CompressionStats) Ratio() float64 {
+	if s.StoredBytes == 0 {
+		return 1
+	}
+	return float64(s.RawBytes) / float64(s.StoredBytes)
+}
+
+// CompressedCache is a PinningCache that stores blocks compressed with flate, and decompresses them each time they are read.
+// It trades the CPU time spent doing so for being able to hold far more of a compressible source in the same memory.
+// Blocks that do not compress are stored as they are.
+//
+// Because Get returns a freshly decompressed copy of each block, the cache does not retain the buffers put into it.
+type CompressedCache struct {
+	// updated atomically; it must stay first, where it is 64-bit aligned even on 32-bit platforms
+	decompressions int64
+
+	// the cache in which the compressed blocks are held, which limits and evicts them by their compressed size
+	Store *MemoryCache
+}
+
+// NewCompressedCache returns a CompressedCache that retains at most limit bytes of compressed, unpinned block data.
+// A limit of zero means that the cache is unbounded.
+func NewCompressedCache(limit int64) *CompressedCache {
+	return &CompressedCache{Store: NewMemoryCache(limit)}
+}
+
+// compressBlock returns the stored form of data: a byte identifying its encoding, its uncompressed length, and its contents.
+func compressBlock(data []byte) []byte {
+	var hdr [1 + binary.MaxVarintLen64]byte
+	hdr[0] = blockStoredFlate
+	n := 1 + binary.PutUvarint(hdr[1:], uint64(len(data)))
+
+	var buf bytes.Buffer
+	buf.Write(hdr[:n])
+
+	w := flateWriters.Get().(*flate.Writer)
+	w.Reset(&buf)
+	_, err := w.Write(data)
+	if err == nil {
+		err = w.Close()
+	}
+	flateWriters.Put(w)
+
+	if err != nil || buf.Len() >= n+len(data) {
+		stored := make([]byte, n+len(data))
+		copy(stored, hdr[:n])
+		stored[0] = blockStoredRaw
+		copy(stored[n:], data)
+		return stored
+	}
+
+	// Don't hold on to the buffer's spare capacity.
+	stored := make([]byte, buf.Len())
+	copy(stored, buf.Bytes())
+	return stored
+}
+
+// storedBlockSize returns the uncompressed length of a block stored by compressBlock.
+func storedBlockSize(stored []byte) (int, bool) {
+	if len(stored) < 2 {
+		return 0, false
+	}
+	l, n := binary.Uvarint(stored[1:])
+	if n <= 0 {
+		return 0, false
+	}
+	return int(l), true
+}
+
+// decompressBlock returns the contents of a block stored by compressBlock.
+func decompressBlock(stored []byte) ([]byte, bool) {
+	if len(stored) < 2 {
+		return nil, false
+	}
+	l, n := binary.Uvarint(stored[1:])
+	if n <= 0 {
+		return nil, false
+	}
+	payload := stored[1+n:]
+
+	switch stored[0] {
+	case blockStoredRaw:
+		data := make([]byte, len(payload))
+		copy(data, payload)
+		return data, true
+	case blockStoredFlate:
+		fr := flateReaders.Get().(io.ReadCloser)
+		defer flateReaders.Put(fr)
+		if err := fr.(flate.Resetter).Reset(bytes.NewReader(payload), nil); err != nil {
+			return nil, false
+		}
+		data := make([]byte, l)
+		if _, err := io.ReadFull(fr, data); err != nil {
+			return nil, false
+		}
+		return data, true
+	}
+	return nil, false
+}
+
+// Get returns a decompressed copy of the data for block i, marking it as recently used.
+func (c *CompressedCache) Get(i int) ([]byte, bool) {
+	stored, ok := c.Store.Get(i)
+	if !ok {
+		return nil, false
+	}
+	if stored[0] == blockStoredFlate {
+		atomic.AddInt64(&c.decompressions, 1)
+	}
+	return decompressBlock(stored)
+}
+
+// Put compresses and stores the data for block i.
+func (c *CompressedCache) Put(i int, data []byte) {
+	c.Store.Put(i, compressBlock(data))
+}
+
+// Has reports whether block i is present.
+func (c *CompressedCache) Has(i int) bool {
+	return c.Store.Has(i)
+}
+
+// Delete removes block i, even if it is pinned.
+func (c *CompressedCache) Delete(i int) {
+	c.Store.Delete(i)
+}
+
+// Coverage returns the ranges of blocks present in the cache.
+func (c *CompressedCache) Coverage() []BlockRange {
+	return c.Store.Coverage()
+}
+
+// Pin prevents block i from being evicted, whether or not it is currently cached.
+func (c *CompressedCache) Pin(i int) {
+	c.Store.Pin(i)
+}
+
+// Unpin releases one pin on block i, making it eligible for eviction once no pins remain.
+func (c *CompressedCache) Unpin(i int) {
+	c.Store.Unpin(i)
+}
+
+// BlockStats returns the uncompressed size of block i and the number of bytes it occupies, and whether it is present.
+func (c *CompressedCache) BlockStats(i int) (raw, stored int, ok bool) {
+	c.Store.view(i, func(data []byte) {
+		raw, ok = storedBlockSize(data)
+		stored = len(data)
+	})
+	if !ok {
+		return 0, 0, false
+	}
+	return raw, stored, true
+}
+
+// Stats returns statistics for the blocks currently held by the cache.
+func (c *CompressedCache) Stats() CompressionStats {
+	s := CompressionStats{Decompressions: atomic.LoadInt64(&c.decompressions)}
+	c.Store.each(func(i int, data []byte) {
+		raw, ok := storedBlockSize(data)
+		if !ok {
+			return
+		}
+		s.Blocks++
+		s.RawBytes += int64(raw)
+		s.StoredBytes += int64(len(data))
+		if data[0] != blockStoredFlate {
+			s.Incompressible++
+		}
+	})
+	return s
+}
@@ -0,0 +1,97 @@
+package ranger
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestCompressBlock(t *testing.T) {
+	compressible := bytes.Repeat([]byte("ranger "), 1000)
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for _, data := range [][]byte{compressible, random, {}} {
+		stored := compressBlock(data)
+		got, ok := decompressBlock(stored)
+		if !ok || !bytes.Equal(got, data) {
+			t.Errorf("round trip of %d bytes failed", len(data))
+		}
+	}
+
+	if stored := compressBlock(compressible); stored[0] != blockStoredFlate || len(stored) >= len(compressible)/4 {
+		t.Errorf("expected %d compressible bytes to be stored compressed, got %d bytes", len(compressible), len(stored))
+	}
+	if stored := compressBlock(random); stored[0] != blockStoredRaw {
+		t.Error("expected random bytes to be stored uncompressed")
+	}
+}
+
+// newTextFetcher returns a memoryFetcher over n bytes of compressible text.
+func newTextFetcher(n int) *memoryFetcher {
+	f := newMemoryFetcher(n)
+	for i := range f.data {
+		f.data[i] = "the quick brown fox jumps over the lazy dog\n"[i%44]
+	}
+	return f
+}
+
+func TestCompressedCache(t *testing.T) {
+	f := newTextFetcher(64 * 256)
+	r := &Reader{Fetcher: f, BlockSize: 256, CompressCache: true}
+
+	b := make([]byte, 1000)
+	for i := 0; i < 2; i++ {
+		if _, err := r.ReadAt(b, 300); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, f.data[300:1300]) {
+			t.Error("data mismatch")
+		}
+	}
+	if calls, _ := f.counts(); calls != 1 {
+		t.Errorf("expected the second read to be served from the cache, made %d fetches", calls)
+	}
+
+	cc := r.Cache.(*CompressedCache)
+	s := cc.Stats()
+	if s.Blocks != 5 || s.RawBytes != 5*256 || s.Incompressible != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if s.Ratio() <= 2 {
+		t.Errorf("expected a compression ratio above 2, got %f", s.Ratio())
+	}
+	if s.Decompressions != 5 {
+		t.Errorf("expected 5 decompressions, got %d", s.Decompressions)
+	}
+	if raw, stored, ok := cc.BlockStats(1); !ok || raw != 256 || stored >= raw {
+		t.Errorf("unexpected stats for block 1: %d raw, %d stored, %v", raw, stored, ok)
+	}
+	if _, _, ok := cc.BlockStats(10); ok {
+		t.Error("expected no stats for an absent block")
+	}
+}
+
+func TestCompressedCacheLimit(t *testing.T) {
+	f := newTextFetcher(64 * 256)
+	r := &Reader{Fetcher: f, BlockSize: 256, CompressCache: true, CacheSize: 1024}
+
+	// The whole source, uncompressed, is 16 times the cache's limit.
+	b := make([]byte, len(f.data))
+	r.ReadAt(b, 0)
+
+	s := r.Cache.(*CompressedCache).Stats()
+	if s.StoredBytes > 1024 {
+		t.Errorf("expected no more than 1024 bytes stored, got %d", s.StoredBytes)
+	}
+	if s.RawBytes <= 1024 {
+		t.Errorf("expected more than 1024 bytes of the source to fit in the cache, got %d", s.RawBytes)
+	}
+
+	r.Pin(0, 256)
+	r.ReadAt(b, 0)
+	r.ReadAt(b[:1024], 4096)
+	if !r.Cache.Has(0) {
+		t.Error("expected a pinned block to remain cached")
+	}
+}
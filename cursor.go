@@ -0,0 +1,94 @@
+package ranger
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Cursor is an io.ReadSeeker over a Reader with its own offset. Any number of Cursors may share one Reader,
+// and with it, the Reader's fetcher and cache; each detects sequential reading and reads ahead independently.
+//
+// A Cursor is safe for concurrent use, though concurrent Reads on one Cursor are serialized.
+type Cursor struct {
+	r *Reader
+
+	mutex sync.Mutex
+	off   int64
+
+	ra  readaheadState
+	seq sequenceDetector
+}
+
+// NewCursor returns a new Cursor over r, positioned at its beginning.
+func (r *Reader) NewCursor() *Cursor {
+	return &Cursor{r: r}
+}
+
+// Read reads len(p) bytes from the ranged-over source at the cursor's offset and advances it.
+// It returns the number of bytes read and the error, if any.
+// EOF is signaled by a zero count with err set to io.EOF.
+func (c *Cursor) Read(p []byte) (int, error) {
+	return c.ReadContext(context.Background(), p)
+}
+
+// ReadContext reads len(p) bytes from the ranged-over source, as Read does, abandoning
+// any fetches required to do so when ctx is done.
+func (c *Cursor) ReadContext(ctx context.Context, p []byte) (int, error) {
+	r := c.r
+	err := r.initContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.off == r.len {
+		return 0, io.EOF
+	}
+
+	off := c.off
+	nread, err := r.readAt(ctx, p, off, r.statSinks(nil), &c.seq)
+	c.off += int64(nread)
+	if r.Readahead > 0 {
+		r.readahead(&c.ra, off, int64(nread))
+	}
+	return nread, err
+}
+
+// Seek sets the offset for the next Read to offset, interpreted
+// according to whence: 0 means relative to the origin of the file, 1 means relative
+// to the current offset, and 2 means relative to the end. It returns the new offset
+// and an error, if any.
+func (c *Cursor) Seek(off int64, whence int) (int64, error) {
+	r := c.r
+	err := r.init()
+	if err != nil {
+		return 0, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch whence {
+	case 0: // set
+	case 1: // cur
+		off = c.off + off
+	case 2: // end
+		off = r.len + off
+	}
+
+	if off > r.len {
+		return 0, errors.New("seek beyond end of file")
+	}
+
+	if off < 0 {
+		return 0, errors.New("seek before beginning of file")
+	}
+
+	c.off = off
+	c.ra.reset(off)
+	return c.off, nil
+}
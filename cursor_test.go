@@ -0,0 +1,78 @@
+package ranger
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestIndependentCursors(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, Readahead: 4}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := r.NewCursor()
+			start := int64(i * 256)
+			if _, err := c.Seek(start, io.SeekStart); err != nil {
+				t.Error(err)
+				return
+			}
+
+			b := make([]byte, 256)
+			if _, err := io.ReadFull(c, b); err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(b, f.data[start:start+256]) {
+				t.Errorf("data mismatch for cursor %d", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// The Reader's own offset is unaffected by its cursors.
+	all, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(all, f.data) {
+		t.Error("data mismatch reading through the Reader")
+	}
+}
+
+func TestSharedCursor(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16}
+	c := r.NewCursor()
+
+	var mutex sync.Mutex
+	var total int
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := make([]byte, 10)
+			for {
+				n, err := c.Read(b)
+				mutex.Lock()
+				total += n
+				mutex.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if total != len(f.data) {
+		t.Errorf("concurrent reads through one cursor read %d bytes of %d", total, len(f.data))
+	}
+}
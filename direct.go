@@ -0,0 +1,129 @@
+package ranger
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// readAtDirect reads len(p) bytes at off, fetching the uncached blocks wholly covered by p directly into it.
+// The partial blocks at either end of p are read through the cache, concurrently. It reports false, having
+// read nothing, if p covers no whole block.
+// invariant: after init(); not in extent mode; p lies within the source
+func (r *Reader) readAtDirect(ctx context.Context, p []byte, off int64, st statSinks) (int, bool, error) {
+	bs := int64(r.BlockSize)
+	end := off + int64(len(p))
+
+	// The whole blocks covered by p, [first, last). The source's final block is whole if p reaches its end.
+	first := int((off + bs - 1) / bs)
+	last := int(end / bs)
+	if end == r.len && end%bs != 0 {
+		last++
+	}
+	if first >= last {
+		return 0, false, nil
+	}
+	startBlock, nblocks := blockRange(off, len(p), r.BlockSize)
+	defer r.holdBlocks(blockSequence(startBlock, nblocks))()
+
+	interiorStart := int64(first) * bs
+	interiorEnd := int64(last) * bs
+	if interiorEnd > end {
+		interiorEnd = end
+	}
+
+	// head and tail, if any, are read concurrently with the direct fetch
+	type partial struct {
+		p   []byte
+		off int64
+	}
+	var partials []partial
+	if off < interiorStart {
+		partials = append(partials, partial{p[:interiorStart-off], off})
+	}
+	if interiorEnd < end {
+		partials = append(partials, partial{p[interiorEnd-off:], interiorEnd})
+	}
+	partialErr := make(chan error, 1)
+	go func() {
+		var blockNumbers []int
+		for _, part := range partials {
+			blockNumbers = append(blockNumbers, int(part.off/bs))
+		}
+		if len(blockNumbers) == 0 {
+			partialErr <- nil
+			return
+		}
+		blocks, err := r.loadBlocks(ctx, blockNumbers, st)
+		if err == nil {
+			for i, part := range partials {
+				if _, err = r.copyRangeToBuffer(part.p, part.off, blocks[i:i+1]); err == io.EOF {
+					err = nil
+				}
+				if err != nil {
+					break
+				}
+			}
+		}
+		partialErr <- err
+	}()
+
+	// Copy whatever is cached, and fetch the runs of blocks that are not.
+	var ranges []ByteRange
+	var dsts [][]byte
+	var hits int
+	for bn := first; bn < last; bn++ {
+		rng := r.blockByteRange(bn)
+		dst := p[rng.Start-off : rng.End+1-off]
+		if data, ok := r.cachedBlock(bn); ok {
+			copy(dst, data)
+			hits++
+			continue
+		}
+
+		if n := len(ranges); n > 0 && ranges[n-1].End+1 == rng.Start {
+			ranges[n-1].End = rng.End
+			dsts[n-1] = p[ranges[n-1].Start-off : rng.End+1-off]
+			continue
+		}
+		ranges = append(ranges, rng)
+		dsts = append(dsts, dst)
+	}
+	st.lookup(hits, last-first-hits)
+
+	var err error
+	if len(ranges) > 0 {
+		var n int64
+		for _, rng := range ranges {
+			n += rng.End - rng.Start + 1
+		}
+
+		fctx, done, serr := r.scheduleFetch(ctx)
+		err = serr
+		if err == nil {
+			var start time.Time
+			err = r.Retry.do(fctx, func() error {
+				start = time.Now()
+				return fetchRangesInto(fctx, r.fetcher, ranges, dsts)
+			})
+			done()
+			if err == nil {
+				r.link.observe(n, time.Since(start))
+				st.fetch(n)
+			}
+		}
+	}
+
+	if perr := <-partialErr; err == nil {
+		err = perr
+	}
+	if err != nil {
+		return 0, true, err
+	}
+
+	st.read(len(p))
+	if end == r.len {
+		err = io.EOF
+	}
+	return len(p), true, err
+}
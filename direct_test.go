@@ -0,0 +1,92 @@
+package ranger
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"testing"
+)
+
+func TestDirectRead(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, DirectReadSize: 64}
+
+	// Cache block 10; the direct read should copy it rather than fetch it again.
+	r.ReadAt(make([]byte, 16), 160)
+
+	b := make([]byte, 300)
+	n, err := r.ReadAt(b, 5)
+	if n != 300 || err != nil {
+		t.Fatalf("expected 300 bytes, got %d (%v)", n, err)
+	}
+	if !bytes.Equal(b, f.data[5:305]) {
+		t.Error("data mismatch")
+	}
+
+	// one fetch for the interior (blocks 1-9 and 11-18), another for the head and tail (blocks 0 and 19)
+	if calls, nranges := f.counts(); calls != 3 || nranges != 5 {
+		t.Errorf("expected 3 fetches of 5 ranges, made %d of %d", calls, nranges)
+	}
+	for _, bn := range []int{0, 10, 19} {
+		if !r.Cache.Has(bn) {
+			t.Errorf("expected block %d to be cached", bn)
+		}
+	}
+	for _, bn := range []int{1, 11, 18} {
+		if r.Cache.Has(bn) {
+			t.Errorf("expected block %d not to be cached", bn)
+		}
+	}
+
+	// Reads shorter than DirectReadSize go through the cache as usual.
+	r.ReadAt(b[:32], 32)
+	if !r.Cache.Has(2) || !r.Cache.Has(3) {
+		t.Error("expected a short read to be cached")
+	}
+}
+
+func TestDirectReadToEnd(t *testing.T) {
+	f := newMemoryFetcher(64*16 + 5)
+	r := &Reader{Fetcher: f, BlockSize: 16, DirectReadSize: 64}
+
+	b := make([]byte, len(f.data))
+	n, err := r.ReadAt(b, 0)
+	if n != len(b) || err != io.EOF {
+		t.Fatalf("expected %d bytes and EOF, got %d and %v", len(b), n, err)
+	}
+	if !bytes.Equal(b, f.data) {
+		t.Error("data mismatch")
+	}
+	if len(r.Cache.Coverage()) != 0 {
+		t.Error("expected a read covering only whole blocks to leave the cache empty")
+	}
+}
+
+func TestDirectReadHTTP(t *testing.T) {
+	u, _ := url.Parse(testServer.URL + "/blocks/bl2")
+	want := make([]byte, 10000)
+	cached, _ := newReaderBlockSize(u, 512)
+	cached.ReadAt(want, 700)
+
+	fetchers := map[string]RangeFetcher{
+		"HTTPRanger":      &HTTPRanger{URL: u, MaxRanges: 2},
+		"ParallelFetcher": &ParallelFetcher{Fetcher: &HTTPRanger{URL: u}, SplitSize: 2048},
+	}
+	for name, fetcher := range fetchers {
+		subtest(t, name, func(t *testing.T) {
+			r := &Reader{Fetcher: fetcher, BlockSize: 512, DirectReadSize: 4096}
+
+			// Cache some of the blocks in the middle, so that the direct read is split into several ranges.
+			r.ReadAt(make([]byte, 512), 2048)
+			r.ReadAt(make([]byte, 512), 5120)
+
+			b := make([]byte, 10000)
+			if _, err := r.ReadAt(b, 700); err != nil {
+				t.Fatal(err)
+			}
+			if md5Sum(b) != md5Sum(want) {
+				t.Error("data mismatch")
+			}
+		})
+	}
+}
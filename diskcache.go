@@ -0,0 +1,340 @@
+package ranger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ResourceIdentifier is implemented by RangeFetchers that can identify the resource they range over.
+//
+// Identity returns a name for the resource (such as its URL) and an opaque version that changes whenever
+// its content does (such as its ETag).
+type ResourceIdentifier interface {
+	Identity() (name, version string, err error)
+}
+
+// identityOf returns the identity of f's resource, for the fetchers that wrap f and identify themselves as it.
+func identityOf(f RangeFetcher) (string, string, error) {
+	if id, ok := f.(ResourceIdentifier); ok {
+		return id.Identity()
+	}
+	return "", "", errors.New("fetcher cannot identify its resource")
+}
+
+// BindingCache is implemented by BlockCaches whose contents belong to one version of one resource.
+//
+// Reader calls Bind once, during initialization and before any other method, with the identity of its
+// fetcher's resource and the Reader's block size.
+type BindingCache interface {
+	BlockCache
+	Bind(name, version string, blockSize int) error
+}
+
+const diskCacheLockName = ".lock"
+const diskCacheBlockExt = ".blk"
+const diskCacheTempPrefix = "tmp"
+
+// diskCacheTempAge is how old a temporary file must be before it is assumed to have been left behind by a writer
+// that crashed, and is removed.
+const diskCacheTempAge = time.Hour
+
+// DiskCache is a BindingCache that persists blocks as files under a directory, so that they survive
+// across processes. Several DiskCaches, in this process or others, may share a directory.
+//
+// Blocks are stored per resource name and version; binding a DiskCache to a new version of a resource
+// discards the blocks stored for any other version. Once the blocks for all resources under the directory
+// exceed MaxSize bytes, the least-recently-used blocks are evicted. Each DiskCache measures the directory again
+// whenever it has stored a tenth of MaxSize since it last did, so that it notices the blocks stored by the others.
+//
+// Because each Reader binds its own cache, every Reader needs its own DiskCache.
+type DiskCache struct {
+	// These are updated atomically; they must stay first, where they are 64-bit aligned even on 32-bit platforms.
+	size       int64 // approximate size of Dir
+	unmeasured int64 // bytes stored since Dir was last measured
+
+	// the directory under which blocks are stored
+	Dir string
+
+	// maximum number of bytes of block data to keep under Dir; zero means no limit
+	MaxSize int64
+
+	path      string // directory for the bound resource version
+	blockSize int
+}
+
+// NewDiskCache returns a DiskCache storing up to maxSize bytes of blocks under dir.
+func NewDiskCache(dir string, maxSize int64) *DiskCache {
+	return &DiskCache{
+		Dir:     dir,
+		MaxSize: maxSize,
+	}
+}
+
+func hashString(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%d:%s", len(p), p)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func (c *DiskCache) lock(exclusive bool) (func(), error) {
+	return lockFile(filepath.Join(c.Dir, diskCacheLockName), exclusive)
+}
+
+// Bind prepares the cache to store blocks of blockSize bytes for the given version of the named resource,
+// removing any blocks stored for other versions.
+func (c *DiskCache) Bind(name, version string, blockSize int) error {
+	resourceDir := filepath.Join(c.Dir, hashString(name))
+	c.path = filepath.Join(resourceDir, hashString(version, strconv.Itoa(blockSize)))
+	c.blockSize = blockSize
+
+	err := os.MkdirAll(c.Dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := c.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	siblings, err := ioutil.ReadDir(resourceDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, fi := range siblings {
+		if p := filepath.Join(resourceDir, fi.Name()); p != c.path {
+			if err = os.RemoveAll(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	err = os.MkdirAll(c.path, 0777)
+	if err != nil {
+		return err
+	}
+
+	files, err := c.blockFiles()
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&c.size, files.size())
+	atomic.StoreInt64(&c.unmeasured, 0)
+	return nil
+}
+
+func (c *DiskCache) blockPath(i int) string {
+	return filepath.Join(c.path, strconv.Itoa(i)+diskCacheBlockExt)
+}
+
+// Get returns the data for block i, marking it as recently used.
+// Blocks that cannot be read are treated as absent, and those that are empty or longer than a block, such as
+// the remains of a corrupted file, are removed.
+func (c *DiskCache) Get(i int) ([]byte, bool) {
+	unlock, err := c.lock(false)
+	if err != nil {
+		return nil, false
+	}
+	defer unlock()
+
+	p := c.blockPath(i)
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+	if len(data) == 0 || len(data) > c.blockSize {
+		if os.Remove(p) == nil {
+			atomic.AddInt64(&c.size, -int64(len(data)))
+		}
+		return nil, false
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+	return data, true
+}
+
+// Put stores the data for block i, then evicts blocks if the directory has outgrown MaxSize.
+// Put is best-effort: blocks that cannot be written are simply not cached.
+func (c *DiskCache) Put(i int, data []byte) {
+	// Write to a temporary file first so that no reader can observe a partial block.
+	f, err := ioutil.TempFile(c.path, diskCacheTempPrefix)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return
+	}
+
+	unlock, err := c.lock(false)
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return
+	}
+	// Overwriting a block replaces its size, rather than adding to it.
+	var replaced int64
+	if fi, err := os.Stat(c.blockPath(i)); err == nil {
+		replaced = fi.Size()
+	}
+	err = os.Rename(f.Name(), c.blockPath(i))
+	unlock()
+	if err != nil {
+		// The version directory may have been removed out from under us by another binding.
+		_ = os.Remove(f.Name())
+		return
+	}
+
+	size := atomic.AddInt64(&c.size, int64(len(data))-replaced)
+	unmeasured := atomic.AddInt64(&c.unmeasured, int64(len(data)))
+	if c.MaxSize > 0 && (size > c.MaxSize || unmeasured > c.MaxSize/10) {
+		// Other caches sharing the directory may have filled it without our knowing; evict measures it again.
+		_ = c.evict()
+	}
+}
+
+// Has reports whether block i is present.
+func (c *DiskCache) Has(i int) bool {
+	_, err := os.Stat(c.blockPath(i))
+	return err == nil
+}
+
+// Delete removes block i.
+func (c *DiskCache) Delete(i int) {
+	unlock, err := c.lock(false)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if fi, err := os.Stat(c.blockPath(i)); err == nil {
+		if os.Remove(c.blockPath(i)) == nil {
+			atomic.AddInt64(&c.size, -fi.Size())
+		}
+	}
+}
+
+// Coverage returns the ranges of blocks present for the bound resource version.
+func (c *DiskCache) Coverage() []BlockRange {
+	names, err := ioutil.ReadDir(c.path)
+	if err != nil {
+		return nil
+	}
+
+	var indices []int
+	for _, fi := range names {
+		if i, ok := blockIndexFromFileName(fi.Name()); ok {
+			indices = append(indices, i)
+		}
+	}
+	sort.Ints(indices)
+	return blockRangesFromIndices(indices)
+}
+
+func blockIndexFromFileName(name string) (int, bool) {
+	if !strings.HasSuffix(name, diskCacheBlockExt) {
+		return 0, false
+	}
+	i, err := strconv.Atoi(strings.TrimSuffix(name, diskCacheBlockExt))
+	return i, err == nil
+}
+
+type diskCacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+type diskCacheFiles []diskCacheFile
+
+func (f diskCacheFiles) Len() int           { return len(f) }
+func (f diskCacheFiles) Less(i, j int) bool { return f[i].modTime.Before(f[j].modTime) }
+func (f diskCacheFiles) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+func (f diskCacheFiles) size() (n int64) {
+	for _, v := range f {
+		n += v.size
+	}
+	return
+}
+
+// blockFiles returns every block file under c.Dir, for all resources. It removes any temporary files
+// older than diskCacheTempAge along the way.
+func (c *DiskCache) blockFiles() (diskCacheFiles, error) {
+	var files diskCacheFiles
+	now := time.Now()
+	err := filepath.Walk(c.Dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed by a concurrent eviction or binding
+				return nil
+			}
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		if _, ok := blockIndexFromFileName(fi.Name()); ok {
+			files = append(files, diskCacheFile{path, fi.Size(), fi.ModTime()})
+		} else if strings.HasPrefix(fi.Name(), diskCacheTempPrefix) && now.Sub(fi.ModTime()) > diskCacheTempAge {
+			_ = os.Remove(path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// evict measures c.Dir and, if it has outgrown MaxSize, removes the least-recently-used blocks under it until it is
+// back within 90% of MaxSize.
+func (c *DiskCache) evict() error {
+	unlock, err := c.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	files, err := c.blockFiles()
+	if err != nil {
+		return err
+	}
+
+	sort.Sort(files)
+
+	size := files.size()
+	target := c.MaxSize - c.MaxSize/10
+	if size <= c.MaxSize {
+		target = size
+	}
+	for _, f := range files {
+		if size <= target {
+			break
+		}
+		if err := os.Remove(f.path); err == nil || os.IsNotExist(err) {
+			size -= f.size
+		}
+	}
+
+	atomic.StoreInt64(&c.size, size)
+	atomic.StoreInt64(&c.unmeasured, 0)
+	if size > c.MaxSize {
+		return errors.New("unable to evict enough blocks from disk cache")
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package ranger
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// versionedFetcher is a memoryFetcher that identifies itself with a fixed name and version.
+type versionedFetcher struct {
+	*memoryFetcher
+	version string
+}
+
+func (v *versionedFetcher) Identity() (string, string, error) {
+	return "memory", v.version, nil
+}
+
+func tempCacheDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "ranger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestDiskCachePersistence(t *testing.T) {
+	dir := tempCacheDir(t)
+	defer os.RemoveAll(dir)
+
+	mf := newMemoryFetcher(8 * 64)
+	read := func(version string) int {
+		f := &versionedFetcher{mf, version}
+		r := &Reader{Fetcher: f, BlockSize: 64, Cache: NewDiskCache(dir, 0)}
+		buf := make([]byte, 8*64)
+		calls, _ := mf.counts()
+		if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, mf.data) {
+			t.Fatal("data mismatch")
+		}
+		after, _ := mf.counts()
+		return after - calls
+	}
+
+	if n := read("v1"); n != 1 {
+		t.Errorf("expected first reader to fetch once, fetched %d times", n)
+	}
+	if n := read("v1"); n != 0 {
+		t.Errorf("expected second reader to be served from disk, fetched %d times", n)
+	}
+	if n := read("v2"); n != 1 {
+		t.Errorf("expected a new version to invalidate the cache, fetched %d times", n)
+	}
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	dir := tempCacheDir(t)
+	defer os.RemoveAll(dir)
+
+	c := NewDiskCache(dir, 4*64)
+	if err := c.Bind("memory", "v1", 64); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 16; i++ {
+		c.Put(i, make([]byte, 64))
+	}
+
+	files, err := c.blockFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files.size() > c.MaxSize {
+		t.Errorf("disk cache holds %d bytes; limit is %d", files.size(), c.MaxSize)
+	}
+	if !c.Has(15) {
+		t.Error("most recently stored block was evicted")
+	}
+}
+
+func TestDiskCacheOverwrite(t *testing.T) {
+	dir := tempCacheDir(t)
+	defer os.RemoveAll(dir)
+
+	c := NewDiskCache(dir, 4*64)
+	if err := c.Bind("memory", "v1", 64); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		c.Put(0, make([]byte, 64))
+	}
+	if c.size != 64 {
+		t.Errorf("expected overwriting a block to leave the cache at 64 bytes, got %d", c.size)
+	}
+}
+
+func TestDiskCacheCorruptBlock(t *testing.T) {
+	dir := tempCacheDir(t)
+	defer os.RemoveAll(dir)
+
+	c := NewDiskCache(dir, 0)
+	if err := c.Bind("memory", "v1", 64); err != nil {
+		t.Fatal(err)
+	}
+	for _, corrupt := range [][]byte{make([]byte, 65), nil} {
+		c.Put(0, make([]byte, 64))
+		if err := ioutil.WriteFile(c.blockPath(0), corrupt, 0666); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.Get(0); ok {
+			t.Errorf("expected a %d-byte block to be treated as absent", len(corrupt))
+		}
+		if c.Has(0) {
+			t.Errorf("expected a %d-byte block to be removed", len(corrupt))
+		}
+	}
+}
+
+func TestDiskCacheSharedLimit(t *testing.T) {
+	dir := tempCacheDir(t)
+	defer os.RemoveAll(dir)
+
+	caches := []*DiskCache{NewDiskCache(dir, 8*64), NewDiskCache(dir, 8*64)}
+	for i, c := range caches {
+		if err := c.Bind("memory"+strconv.Itoa(i), "v1", 64); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range caches {
+		for i := 0; i < 8; i++ {
+			c.Put(i, make([]byte, 64))
+		}
+	}
+
+	files, err := caches[0].blockFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files.size() > 8*64 {
+		t.Errorf("caches sharing a directory hold %d bytes between them; limit is %d", files.size(), 8*64)
+	}
+}
+
+func TestDiskCacheRemovesStaleTempFiles(t *testing.T) {
+	dir := tempCacheDir(t)
+	defer os.RemoveAll(dir)
+
+	c := NewDiskCache(dir, 0)
+	if err := c.Bind("memory", "v1", 64); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile(c.path, diskCacheTempPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	old := time.Now().Add(-2 * diskCacheTempAge)
+	if err := os.Chtimes(f.Name(), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewDiskCache(dir, 0).Bind("memory", "v1", 64); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Error("expected a temporary file left behind by a crashed writer to be removed")
+	}
+}
+
+func TestDiskCacheSharedDirectory(t *testing.T) {
+	dir := tempCacheDir(t)
+	defer os.RemoveAll(dir)
+
+	mf := newMemoryFetcher(64 * 64)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := &Reader{Fetcher: &versionedFetcher{mf, "v1"}, BlockSize: 64, Cache: NewDiskCache(dir, 16*64)}
+			b := make([]byte, 100)
+			for off := int64(i * 64); off < 60*64; off += 200 {
+				if _, err := r.ReadAt(b, off); err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(b, mf.data[off:off+100]) {
+					t.Errorf("data mismatch at %d", off)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestDiskCacheHTTP(t *testing.T) {
+	dir := tempCacheDir(t)
+	defer os.RemoveAll(dir)
+
+	sum := "8a4653b85c77f911e9c1f2fdb8d19e87"
+	u, _ := url.Parse(testServer.URL + "/blocks/bl1")
+	for i := 0; i < 2; i++ {
+		r := &Reader{Fetcher: &HTTPRanger{URL: u}, BlockSize: 512, Cache: NewDiskCache(dir, 0)}
+		b := make([]byte, 1024)
+		if _, err := r.ReadAt(b, 1024); err != nil {
+			t.Fatal(err)
+		}
+		if s := md5Sum(b); s != sum {
+			t.Errorf("Mismatch: Expected %s, got %s", sum, s)
+		}
+	}
+}
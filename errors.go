@@ -1,6 +1,10 @@
 package ranger
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 var (
 	// ErrResourceChanged is the error returned by Read when the underlying resource's integrity can no longer be verified.
@@ -10,3 +14,15 @@ var (
 	// ErrResourceNotFound is returned by the first Read operation that determines that a resource is inaccessible.
 	ErrResourceNotFound = errors.New("resource not found")
 )
+
+// HTTPStatusError is the error returned when an HTTP server answers a request with an unexpected status.
+type HTTPStatusError struct {
+	StatusCode int
+
+	// how long the server asked that the request not be retried for, in its Retry-After header; zero if it didn't
+	RetryAfter time.Duration
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected response (status %d)", e.StatusCode)
+}
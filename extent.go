@@ -0,0 +1,461 @@
+package ranger
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMinFetchSize is the default minimum number of bytes fetched for each missing interval in extent mode.
+const DefaultMinFetchSize int64 = 4096
+
+// maxExtentSize is the size beyond which adjacent extents are no longer merged, so that eviction remains reasonably granular.
+const maxExtentSize = 16 * 1024 * 1024
+
+// span is a half-open interval of bytes
+type span struct {
+	start, end int64
+}
+
+func (s span) empty() bool {
+	return s.end <= s.start
+}
+
+func (s span) intersect(o span) span {
+	if o.start > s.start {
+		s.start = o.start
+	}
+	if o.end < s.end {
+		s.end = o.end
+	}
+	if s.end < s.start {
+		s.end = s.start
+	}
+	return s
+}
+
+func (s span) byteRange() ByteRange {
+	return ByteRange{s.start, s.end - 1}
+}
+
+// spansByStart sorts spans by their start offsets.
+type spansByStart []span
+
+func (s spansByStart) Len() int           { return len(s) }
+func (s spansByStart) Less(i, j int) bool { return s[i].start < s[j].start }
+func (s spansByStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// subtractSpan returns the parts of spans not covered by cover
+func subtractSpan(spans []span, cover span) []span {
+	var out []span
+	for _, s := range spans {
+		i := s.intersect(cover)
+		if i.empty() {
+			out = append(out, s)
+			continue
+		}
+		if s.start < i.start {
+			out = append(out, span{s.start, i.start})
+		}
+		if i.end < s.end {
+			out = append(out, span{i.end, s.end})
+		}
+	}
+	return out
+}
+
+// extent is a contiguous run of cached bytes
+type extent struct {
+	start int64
+	data  []byte
+	used  uint64 // the cache's clock at the last access
+}
+
+func (e *extent) span() span {
+	return span{e.start, e.start + int64(len(e.data))}
+}
+
+// inflightExtent is an interval that is being fetched. Its data and error are valid once done is closed.
+type inflightExtent struct {
+	span
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// extentCache stores fetched bytes as variable-size extents, merging adjacent extents as they fill in,
+// and evicting the least-recently-used unpinned extents once it holds more than limit bytes.
+type extentCache struct {
+	mutex sync.Mutex
+
+	limit int64 // <= 0 means no limit
+	size  int64
+	clock uint64
+
+	extents  []*extent // sorted by start; non-overlapping
+	inflight []*inflightExtent
+	pins     map[span]int
+}
+
+func newExtentCache(limit int64) *extentCache {
+	return &extentCache{
+		limit: limit,
+		pins:  make(map[span]int),
+	}
+}
+
+// overlapping returns the index of the first extent that ends after s begins, and the index after the last
+// extent that begins before s ends
+// invariant: c.mutex is held
+func (c *extentCache) overlapping(s span) (int, int) {
+	first := sort.Search(len(c.extents), func(i int) bool {
+		return c.extents[i].span().end > s.start
+	})
+	last := first
+	for last < len(c.extents) && c.extents[last].start < s.end {
+		last++
+	}
+	return first, last
+}
+
+// nextCovered returns the start of the first extent or in-flight interval beginning at or after off, or limit if there is none before it.
+// invariant: c.mutex is held
+func (c *extentCache) nextCovered(off, limit int64) int64 {
+	i := sort.Search(len(c.extents), func(i int) bool {
+		return c.extents[i].start >= off
+	})
+	if i < len(c.extents) && c.extents[i].start < limit {
+		limit = c.extents[i].start
+	}
+	for _, f := range c.inflight {
+		if f.start >= off && f.start < limit {
+			limit = f.start
+		}
+	}
+	return limit
+}
+
+// insert caches data fetched at start, merging it with the extents adjacent to it.
+// invariant: c.mutex is held; data does not overlap any cached extent
+func (c *extentCache) insert(start int64, data []byte) {
+	s := span{start, start + int64(len(data))}
+	i := sort.Search(len(c.extents), func(i int) bool {
+		return c.extents[i].start >= s.start
+	})
+	c.clock++
+	c.size += int64(len(data))
+
+	var left *extent
+	if i > 0 && c.extents[i-1].span().end == s.start && len(c.extents[i-1].data)+len(data) <= maxExtentSize {
+		left = c.extents[i-1]
+		left.data = append(left.data, data...)
+		left.used = c.clock
+	} else {
+		left = &extent{start: start, data: data, used: c.clock}
+		c.extents = append(c.extents, nil)
+		copy(c.extents[i+1:], c.extents[i:])
+		c.extents[i] = left
+		i++
+	}
+
+	if i < len(c.extents) && c.extents[i].start == s.end && len(left.data)+len(c.extents[i].data) <= maxExtentSize {
+		left.data = append(left.data, c.extents[i].data...)
+		c.extents = append(c.extents[:i], c.extents[i+1:]...)
+	}
+
+	c.evict()
+}
+
+// evict removes unpinned extents, least recently used first, until the cache is within its limit.
+// invariant: c.mutex is held
+func (c *extentCache) evict() {
+	for c.limit > 0 && c.size > c.limit {
+		victim := -1
+		for i, e := range c.extents {
+			if (victim == -1 || e.used < c.extents[victim].used) && !c.pinned(e.span()) {
+				victim = i
+			}
+		}
+		if victim == -1 {
+			return
+		}
+		c.size -= int64(len(c.extents[victim].data))
+		c.extents = append(c.extents[:victim], c.extents[victim+1:]...)
+	}
+}
+
+// invariant: c.mutex is held
+func (c *extentCache) pinned(s span) bool {
+	for p := range c.pins {
+		if !s.intersect(p).empty() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *extentCache) pin(s span) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pins[s]++
+}
+
+func (c *extentCache) unpin(s span) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.pins[s] <= 1 {
+		delete(c.pins, s)
+		c.evict()
+		return
+	}
+	c.pins[s]--
+}
+
+// coverage returns the cached intervals as ByteRanges.
+func (c *extentCache) coverage() []ByteRange {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var ranges []ByteRange
+	for _, e := range c.extents {
+		rng := e.span().byteRange()
+		if n := len(ranges); n > 0 && ranges[n-1].End+1 == rng.Start {
+			ranges[n-1].End = rng.End
+			continue
+		}
+		ranges = append(ranges, rng)
+	}
+	return ranges
+}
+
+func (c *extentCache) completeInflight(f *inflightExtent, data []byte, err error) {
+	c.mutex.Lock()
+	if data != nil {
+		c.insert(f.start, data)
+	}
+	for i, v := range c.inflight {
+		if v == f {
+			c.inflight = append(c.inflight[:i], c.inflight[i+1:]...)
+			break
+		}
+	}
+	c.mutex.Unlock()
+
+	f.data, f.err = data, err
+	close(f.done)
+}
+
+// extentLoad is one interval requested of loadExtents, and its destination
+type extentLoad struct {
+	want span
+	dst  []byte // may be nil, when the interval need only be cached
+	need []span // the parts of want not yet satisfied
+}
+
+// fulfill copies the bytes of data, which begin at start, into the loads that need them.
+func fulfill(loads []*extentLoad, start int64, data []byte) {
+	s := span{start, start + int64(len(data))}
+	for _, l := range loads {
+		for _, part := range l.need {
+			ov := part.intersect(s)
+			if ov.empty() {
+				continue
+			}
+			if l.dst != nil {
+				copy(l.dst[ov.start-l.want.start:ov.end-l.want.start], data[ov.start-start:ov.end-start])
+			}
+			l.need = subtractSpan(l.need, ov)
+		}
+	}
+}
+
+// satisfied reports, for each of loads, whether it needs nothing further.
+func satisfied(loads []*extentLoad) []bool {
+	done := make([]bool, len(loads))
+	for i, l := range loads {
+		done[i] = len(l.need) == 0
+	}
+	return done
+}
+
+// loadExtents satisfies each of wants, copying its bytes into the corresponding entry in dsts (if it is not nil), fetching
+// all of the intervals that are neither cached nor already being fetched in a single request. Missing intervals are extended
+// to at least r.MinFetchSize bytes, and the gaps between them are filled according to r.Coalesce.
+// It reports, for each of wants, whether it was satisfied in full, even when it returns an error.
+// invariant: after init(); in extent mode; wants lie within the source
+func (r *Reader) loadExtents(ctx context.Context, wants []span, dsts [][]byte, st statSinks) ([]bool, error) {
+	c := r.extents
+	loads := make([]*extentLoad, len(wants))
+	for i, w := range wants {
+		loads[i] = &extentLoad{want: w, dst: dsts[i], need: []span{w}}
+	}
+
+	minFetch := r.MinFetchSize
+	if minFetch <= 0 {
+		minFetch = DefaultMinFetchSize
+	}
+
+	for first := true; ; first = false {
+		var gaps []span
+		waits := make(map[*inflightExtent]struct{})
+
+		c.mutex.Lock()
+		c.clock++
+		for _, l := range loads {
+			for _, part := range l.need {
+				first, last := c.overlapping(part)
+				for _, e := range c.extents[first:last] {
+					e.used = c.clock
+					fulfill([]*extentLoad{l}, e.start, e.data)
+				}
+			}
+
+			for _, part := range l.need {
+				uncovered := []span{part}
+				for _, f := range c.inflight {
+					if !part.intersect(f.span).empty() {
+						waits[f] = struct{}{}
+						uncovered = subtractSpan(uncovered, f.span)
+					}
+				}
+				gaps = append(gaps, uncovered...)
+			}
+		}
+
+		claims := r.extentClaims(gaps, minFetch)
+		claimed := make([]*inflightExtent, len(claims))
+		for i, s := range claims {
+			claimed[i] = &inflightExtent{span: s, done: make(chan struct{})}
+		}
+		c.inflight = append(c.inflight, claimed...)
+		c.mutex.Unlock()
+
+		if first {
+			if len(claimed) == 0 && len(waits) == 0 {
+				st.lookup(1, 0)
+			} else {
+				st.lookup(0, 1)
+			}
+		}
+
+		if len(claimed) == 0 && len(waits) == 0 {
+			return satisfied(loads), nil
+		}
+
+		if len(claimed) > 0 {
+			err := r.fetchClaimedExtents(ctx, claimed, loads, st)
+			if err != nil {
+				return satisfied(loads), err
+			}
+		}
+
+		for f := range waits {
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return satisfied(loads), ctx.Err()
+			}
+			if f.err == errFetchAbandoned {
+				if ctx.Err() != nil {
+					return satisfied(loads), ctx.Err()
+				}
+				// abandoned by whoever was fetching it; we'll claim it ourselves next time around
+				continue
+			}
+			if f.err != nil {
+				return satisfied(loads), f.err
+			}
+			fulfill(loads, f.start, f.data)
+		}
+	}
+}
+
+// extentClaims returns the intervals to fetch in order to fill gaps: sorted, extended to at least minFetch bytes
+// where nothing else is cached or in flight, and merged according to r.Coalesce.
+// invariant: r.extents.mutex is held
+func (r *Reader) extentClaims(gaps []span, minFetch int64) []span {
+	sort.Sort(spansByStart(gaps))
+
+	var claims []span
+	for _, g := range gaps {
+		if g.end-g.start < minFetch {
+			g.end = r.extents.nextCovered(g.end, g.start+minFetch)
+			if g.end > r.len {
+				g.end = r.len
+			}
+		}
+
+		if n := len(claims); n > 0 {
+			last := &claims[n-1]
+			if g.start <= last.end {
+				if g.end > last.end {
+					last.end = g.end
+				}
+				continue
+			}
+			if r.Coalesce != nil && r.extents.nextCovered(last.end, g.start) == g.start && r.Coalesce.fillsGap(g.start-last.end) {
+				last.end = g.end
+				continue
+			}
+		}
+		claims = append(claims, g)
+	}
+	return claims
+}
+
+// fetchClaimedExtents fetches the given intervals, which the caller has claimed, caches them, copies their contents
+// into loads, and releases anyone waiting on them.
+func (r *Reader) fetchClaimedExtents(ctx context.Context, claimed []*inflightExtent, loads []*extentLoad, st statSinks) (err error) {
+	var blox []Block
+	var abandoned bool // whether we gave up on the fetch, rather than it having failed
+	defer func() {
+		for i, f := range claimed {
+			var data []byte
+			ferr := err
+			if abandoned {
+				ferr = errFetchAbandoned
+			} else if ferr == nil {
+				if i < len(blox) && int64(len(blox[i].Data)) == f.end-f.start {
+					data = blox[i].Data
+					fulfill(loads, f.start, data)
+				} else {
+					ferr = errShortFetch
+					err = ferr
+				}
+			}
+			r.extents.completeInflight(f, data, ferr)
+		}
+	}()
+
+	var n int64
+	ranges := make([]ByteRange, len(claimed))
+	for i, f := range claimed {
+		ranges[i] = f.span.byteRange()
+		n += f.end - f.start
+	}
+
+	fctx, done, err := r.scheduleFetch(ctx)
+	if err != nil {
+		abandoned = ctx.Err() != nil || err == ErrFetchQueueFull
+		return err
+	}
+	defer done()
+
+	var start time.Time
+	err = r.Retry.do(fctx, func() (err error) {
+		start = time.Now()
+		blox, err = r.fetcher.FetchRangesContext(fctx, ranges)
+		if err == nil {
+			err = checkBlocks(ranges, blox)
+		}
+		return err
+	})
+	if err == nil {
+		r.link.observe(n, time.Since(start))
+		st.fetch(n)
+	}
+	// Checked here, before done cancels the fetch's context.
+	abandoned = err != nil && fctx.Err() != nil
+	return err
+}
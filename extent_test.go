@@ -0,0 +1,171 @@
+package ranger
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSubtractSpan(t *testing.T) {
+	spans := subtractSpan([]span{{0, 10}, {20, 30}}, span{5, 25})
+	expected := []span{{0, 5}, {25, 30}}
+	if !reflect.DeepEqual(spans, expected) {
+		t.Errorf("expected %v, got %v", expected, spans)
+	}
+}
+
+func TestExtentReadFetchesOnlyMissing(t *testing.T) {
+	f := newMemoryFetcher(4096)
+	r := &Reader{Fetcher: f, Extents: true, MinFetchSize: 1}
+
+	b := make([]byte, 10)
+	r.ReadAt(b, 1020)
+	if !bytes.Equal(b, f.data[1020:1030]) {
+		t.Fatal("data mismatch")
+	}
+	if expected := []ByteRange{{1020, 1029}}; !reflect.DeepEqual(f.ranges, expected) {
+		t.Errorf("expected to fetch %v, fetched %v", expected, f.ranges)
+	}
+
+	r.ReadAt(b, 1040)
+	b = make([]byte, 40)
+	r.ReadAt(b, 1010)
+	if !bytes.Equal(b, f.data[1010:1050]) {
+		t.Fatal("data mismatch")
+	}
+	expected := []ByteRange{{1020, 1029}, {1040, 1049}, {1010, 1019}, {1030, 1039}}
+	if !reflect.DeepEqual(f.ranges, expected) {
+		t.Errorf("expected to fetch %v, fetched %v", expected, f.ranges)
+	}
+
+	// The four fetched intervals are adjacent, and so should have merged into one extent.
+	if n := len(r.extents.extents); n != 1 {
+		t.Errorf("expected adjacent extents to merge into one, have %d", n)
+	}
+	if ranges, _ := r.CachedRanges(); !reflect.DeepEqual(ranges, []ByteRange{{1010, 1049}}) {
+		t.Errorf("unexpected cached ranges %v", ranges)
+	}
+}
+
+func TestExtentMinFetchSize(t *testing.T) {
+	f := newMemoryFetcher(4096)
+	r := &Reader{Fetcher: f, Extents: true, MinFetchSize: 100}
+
+	b := make([]byte, 10)
+	r.ReadAt(b, 150)
+	r.ReadAt(b, 100)
+	r.ReadAt(b, 4090)
+	expected := []ByteRange{{150, 249}, {100, 149}, {4090, 4095}}
+	if !reflect.DeepEqual(f.ranges, expected) {
+		t.Errorf("expected to fetch %v, fetched %v", expected, f.ranges)
+	}
+}
+
+func TestExtentEviction(t *testing.T) {
+	f := newMemoryFetcher(4096)
+	r := &Reader{Fetcher: f, Extents: true, MinFetchSize: 1, CacheSize: 100}
+
+	r.Pin(0, 10)
+	b := make([]byte, 50)
+	for _, off := range []int64{0, 1000, 2000, 3000} {
+		if _, err := r.ReadAt(b, off); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, f.data[off:off+50]) {
+			t.Fatal("data mismatch")
+		}
+	}
+
+	if r.extents.size > 100 {
+		t.Errorf("extent cache holds %d bytes; limit is 100", r.extents.size)
+	}
+	ranges, _ := r.CachedRanges()
+	if expected := []ByteRange{{0, 49}, {3000, 3049}}; !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("expected cached ranges %v, got %v", expected, ranges)
+	}
+}
+
+func TestExtentConcurrentReads(t *testing.T) {
+	f := newMemoryFetcher(64 * 1024)
+	r := &Reader{Fetcher: f, Extents: true, MinFetchSize: 100}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b := make([]byte, 777)
+			for off := int64(i * 313); off+777 < int64(len(f.data)); off += 1531 {
+				if _, err := r.ReadAt(b, off); err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(b, f.data[off:off+777]) {
+					t.Errorf("data mismatch at %d", off)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// No byte should have been fetched twice.
+	var fetched int64
+	for _, rng := range f.ranges {
+		fetched += rng.End - rng.Start + 1
+	}
+	if fetched > int64(len(f.data)) {
+		t.Errorf("fetched %d bytes of a %d byte source", fetched, len(f.data))
+	}
+}
+
+func TestExtentZipFilePartialRead(t *testing.T) {
+	u, _ := url.Parse(testServer.URL + "/b.zip")
+	hpr := &Reader{Fetcher: &HTTPRanger{URL: u}, Extents: true, MinFetchSize: 16}
+	length, err := hpr.Length()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(hpr, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bytes := make([]byte, zr.File[0].UncompressedSize64)
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	if _, err := io.ReadFull(rc, bytes); err != nil {
+		t.Fatal(err)
+	}
+	expected := "6b210f6fe0bac9de21e11acbc6bb292b"
+	if s := md5Sum(bytes); expected != s {
+		t.Fatalf("sum mismatch on %s: expected %s, got %s", zr.File[0].Name, expected, s)
+	}
+}
+
+func TestExtentPrefetch(t *testing.T) {
+	f := newMemoryFetcher(4096)
+	r := &Reader{Fetcher: f, Extents: true, MinFetchSize: 1}
+
+	if err := r.Prefetch([]ByteRange{{100, 199}, {300, 349}, {4000, 5000}}); err != nil {
+		t.Fatal(err)
+	}
+	if calls, _ := f.counts(); calls != 1 {
+		t.Errorf("expected prefetch to make one request, made %d", calls)
+	}
+
+	b := make([]byte, 50)
+	r.ReadAt(b, 120)
+	if calls, _ := f.counts(); calls != 1 {
+		t.Error("read of prefetched region made a request")
+	}
+}
@@ -108,7 +108,11 @@ func newEtaggingContentHandler(name string, rs io.ReadSeeker, modtime time.Time)
 	etag := fmt.Sprintf("\"%02x\"", sum)
 	rs.Seek(0, os.SEEK_SET)
 
+	// rs is shared by every request, and it is not safe for concurrent use.
+	var mutex sync.Mutex
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
 		w.Header().Set("ETag", etag)
 		http.ServeContent(w, r, name, modtime, rs)
 	})
@@ -171,6 +175,17 @@ func initTestServer() {
 		w.WriteHeader(http.StatusOK)
 	}), newStatusHandler(http.StatusNotFound)))
 
+	serveMux.Handle("/stalls", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1024")
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Etag", "\"abcdef\"")
+		if r.Method == "HEAD" && r.URL.Query().Get("head") != "stall" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		<-r.Context().Done()
+	}))
+
 	serveMux.Handle("/no_ranges", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Accept-Ranges", "")
 		w.WriteHeader(http.StatusOK)
@@ -0,0 +1,222 @@
+package ranger
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHedgePercentile is the default percentile of recent fetch latencies after which a HedgedFetcher hedges.
+	DefaultHedgePercentile = 0.95
+
+	// DefaultMaxHedgeFraction is the default upper bound on the number of hedged requests a HedgedFetcher makes,
+	// as a fraction of its fetches.
+	DefaultMaxHedgeFraction = 0.05
+)
+
+const (
+	// number of recent fetch latencies from which a HedgedFetcher computes its percentile
+	hedgeLatencySamples = 128
+
+	// number of fetches a HedgedFetcher must have seen before it will hedge by percentile
+	hedgeMinSamples = 16
+
+	// number of hedges a HedgedFetcher may send beyond MaxHedgeFraction, so that its first slow fetch can be hedged
+	hedgeInitialBudget = 1
+)
+
+// HedgeStats counts the fetches made by a HedgedFetcher.
+type HedgeStats struct {
+	Fetches   int64 // fetches requested of the HedgedFetcher
+	Hedges    int64 // duplicate requests sent because the first was slow to answer
+	HedgeWins int64 // duplicate requests that answered before the first
+}
+
+// HedgedFetcher is a RangeFetcher that cuts the latency of its slowest fetches by hedging them: when a fetch made
+// through Fetcher has not answered within a delay, it sends the same fetch again, uses whichever answers first,
+// and abandons the other. Fetcher should abandon a fetch when its context is done, as HTTPRanger does.
+//
+// The delay is either fixed, or a percentile of the latencies of recent fetches. So that hedging cannot
+// double the load on a source that is slow across the board, no more than MaxHedgeFraction of fetches are hedged.
+type HedgedFetcher struct {
+	// the fetcher through which fetches, and their hedges, are made
+	Fetcher RangeFetcher
+
+	// how long to wait for a fetch before hedging it; zero means that the delay follows Percentile
+	Delay time.Duration
+
+	// percentile, between 0 and 1, of the latencies of recent fetches after which to hedge, if Delay is zero;
+	// defaults to DefaultHedgePercentile
+	Percentile float64
+
+	// maximum number of hedged requests, as a fraction of all fetches; defaults to DefaultMaxHedgeFraction.
+	// One hedge is allowed beyond it, so that a slow fetch is hedged even before many fetches have been made.
+	MaxHedgeFraction float64
+
+	mutex     sync.Mutex
+	latencies []time.Duration // ring of the most recent fetch latencies
+	next      int             // index in latencies of the next to be replaced
+	stats     HedgeStats
+}
+
+// ExpectedLength returns the length, in bytes, of the ranged-over source.
+func (h *HedgedFetcher) ExpectedLength() (int64, error) {
+	return h.Fetcher.ExpectedLength()
+}
+
+// ExpectedLengthContext returns the length, in bytes, of the ranged-over source, abandoning the attempt to
+// determine it when ctx is done if the underlying fetcher supports that.
+func (h *HedgedFetcher) ExpectedLengthContext(ctx context.Context) (int64, error) {
+	return expectedLength(ctx, h.Fetcher)
+}
+
+// Identity returns the identity of the underlying fetcher's resource, if it has one.
+func (h *HedgedFetcher) Identity() (string, string, error) {
+	return identityOf(h.Fetcher)
+}
+
+// Stats returns counts of the fetches made so far.
+func (h *HedgedFetcher) Stats() HedgeStats {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.stats
+}
+
+// FetchRanges fetches ranges, hedging the fetch if it is slow to answer.
+func (h *HedgedFetcher) FetchRanges(ranges []ByteRange) ([]Block, error) {
+	return h.FetchRangesContext(context.Background(), ranges)
+}
+
+// hedgeResult is the outcome of one of the requests making up a hedged fetch.
+type hedgeResult struct {
+	fetchResult
+	hedge bool
+}
+
+// FetchRangesContext fetches ranges, as FetchRanges does, abandoning the fetch and any hedge when ctx is done.
+// If one of the two requests fails, the fetch waits for the other; it fails only if both do.
+func (h *HedgedFetcher) FetchRangesContext(ctx context.Context, ranges []ByteRange) ([]Block, error) {
+	fetcher := ContextFetcher(h.Fetcher)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // abandons the request that lost
+
+	results := make(chan hedgeResult, 2)
+	send := func(hedge bool) {
+		go func() {
+			blox, err := fetcher.FetchRangesContext(ctx, ranges)
+			results <- hedgeResult{fetchResult{blox, err}, hedge}
+		}()
+	}
+
+	delay, hedging := h.begin()
+	start := time.Now()
+	send(false)
+	outstanding := 1
+
+	var timeout <-chan time.Time
+	if hedging {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var firstErr error
+	for {
+		select {
+		case res := <-results:
+			outstanding--
+			if res.err == nil {
+				// The fetch took as long as it did to answer, however late the hedge that answered it was sent.
+				h.finish(time.Since(start), res.hedge)
+				return res.blox, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if outstanding == 0 {
+				return nil, firstErr
+			}
+
+		case <-timeout:
+			timeout = nil
+			if h.allowHedge() {
+				send(true)
+				outstanding++
+			}
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// begin counts a new fetch, and returns the delay after which to hedge it and whether it may be hedged at all.
+func (h *HedgedFetcher) begin() (time.Duration, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.stats.Fetches++
+	if h.Delay > 0 {
+		return h.Delay, true
+	}
+	if len(h.latencies) < hedgeMinSamples {
+		return 0, false
+	}
+
+	p := h.Percentile
+	if p <= 0 || p > 1 {
+		p = DefaultHedgePercentile
+	}
+	sorted := append([]time.Duration(nil), h.latencies...)
+	sort.Sort(durations(sorted))
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i], true
+}
+
+// durations sorts latencies, shortest first.
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// allowHedge reports whether a hedge may be sent without exceeding h.MaxHedgeFraction, and counts it if so.
+func (h *HedgedFetcher) allowHedge() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	frac := h.MaxHedgeFraction
+	if frac <= 0 {
+		frac = DefaultMaxHedgeFraction
+	}
+	if float64(h.stats.Hedges+1) > frac*float64(h.stats.Fetches)+hedgeInitialBudget {
+		return false
+	}
+	h.stats.Hedges++
+	return true
+}
+
+// finish records the latency of a fetch, from when it began until it was answered, and whether the hedge answered it.
+func (h *HedgedFetcher) finish(latency time.Duration, hedge bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if hedge {
+		h.stats.HedgeWins++
+	}
+	if len(h.latencies) < hedgeLatencySamples {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % hedgeLatencySamples
+}
@@ -0,0 +1,176 @@
+package ranger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// laggingFetcher is a memoryFetcher whose fetches stall, until their context is done, whenever stall returns true
+// for the number of the call (counting from 1.) It counts the stalled fetches that were abandoned.
+type laggingFetcher struct {
+	*memoryFetcher
+	stall func(call int) bool
+	fail  error
+
+	mutex     sync.Mutex
+	n         int
+	abandoned int
+}
+
+func (s *laggingFetcher) FetchRangesContext(ctx context.Context, ranges []ByteRange) ([]Block, error) {
+	s.mutex.Lock()
+	s.n++
+	call := s.n
+	s.mutex.Unlock()
+
+	if s.stall != nil && s.stall(call) {
+		<-ctx.Done()
+		s.mutex.Lock()
+		s.abandoned++
+		s.mutex.Unlock()
+		return nil, ctx.Err()
+	}
+	if s.fail != nil {
+		return nil, s.fail
+	}
+	return s.memoryFetcher.FetchRanges(ranges)
+}
+
+func (s *laggingFetcher) counts() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.n, s.abandoned
+}
+
+func TestHedgedFetcher(t *testing.T) {
+	subtest(t, "FixedDelay", func(t *testing.T) {
+		sf := &laggingFetcher{memoryFetcher: newMemoryFetcher(64 * 16), stall: func(call int) bool { return call == 1 }}
+		h := &HedgedFetcher{Fetcher: sf, Delay: 10 * time.Millisecond, MaxHedgeFraction: 1}
+
+		blox, err := h.FetchRanges([]ByteRange{{16, 31}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(blox[0].Data, sf.data[16:32]) {
+			t.Error("data mismatch")
+		}
+
+		if stats := h.Stats(); stats != (HedgeStats{Fetches: 1, Hedges: 1, HedgeWins: 1}) {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+		// the hedge answered, but the fetch had already waited out the delay
+		h.mutex.Lock()
+		latency := h.latencies[0]
+		h.mutex.Unlock()
+		if latency < h.Delay {
+			t.Errorf("recorded latency %v, shorter than the hedge delay %v", latency, h.Delay)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, abandoned := sf.counts(); abandoned == 1 {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Error("expected the stalled request to be abandoned")
+	})
+
+	subtest(t, "FastFetchesAreNotHedged", func(t *testing.T) {
+		sf := &laggingFetcher{memoryFetcher: newMemoryFetcher(64 * 16)}
+		h := &HedgedFetcher{Fetcher: sf, Delay: time.Second, MaxHedgeFraction: 1}
+
+		for i := 0; i < 4; i++ {
+			if _, err := h.FetchRanges([]ByteRange{{0, 15}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if calls, _ := sf.counts(); calls != 4 {
+			t.Errorf("expected 4 requests, got %d", calls)
+		}
+	})
+
+	subtest(t, "Percentile", func(t *testing.T) {
+		sf := &laggingFetcher{memoryFetcher: newMemoryFetcher(64 * 16), stall: func(call int) bool { return call == hedgeMinSamples+1 }}
+		h := &HedgedFetcher{Fetcher: sf, Percentile: 0.9, MaxHedgeFraction: 1}
+
+		for i := 0; i < hedgeMinSamples; i++ {
+			if _, err := h.FetchRanges([]ByteRange{{0, 15}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if stats := h.Stats(); stats.Hedges != 0 {
+			t.Errorf("expected no hedges before %d fetches had been seen, got %d", hedgeMinSamples, stats.Hedges)
+		}
+
+		// One of this fetch's requests stalls; it would never return were it not hedged.
+		if _, err := h.FetchRanges([]ByteRange{{0, 15}}); err != nil {
+			t.Fatal(err)
+		}
+		if stats := h.Stats(); stats.Hedges != 1 {
+			t.Errorf("expected the stalled fetch to be hedged, got %+v", stats)
+		}
+	})
+
+	subtest(t, "MaxHedgeFraction", func(t *testing.T) {
+		sf := &laggingFetcher{memoryFetcher: newMemoryFetcher(64 * 16), stall: func(call int) bool { return call%2 == 1 }}
+		h := &HedgedFetcher{Fetcher: sf, Delay: time.Millisecond, MaxHedgeFraction: 0.25}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _ = h.FetchRangesContext(ctx, []ByteRange{{0, 15}})
+			}()
+		}
+		wg.Wait()
+
+		if stats := h.Stats(); stats.Fetches != 8 || stats.Hedges > 2+hedgeInitialBudget {
+			t.Errorf("expected at most %d hedges of 8 fetches, got %+v", 2+hedgeInitialBudget, stats)
+		}
+	})
+
+	subtest(t, "FirstFetch", func(t *testing.T) {
+		// Even the default fraction allows the very first fetch to be hedged.
+		sf := &laggingFetcher{memoryFetcher: newMemoryFetcher(64 * 16), stall: func(call int) bool { return call == 1 }}
+		h := &HedgedFetcher{Fetcher: sf, Delay: 10 * time.Millisecond}
+
+		if _, err := h.FetchRanges([]ByteRange{{0, 15}}); err != nil {
+			t.Fatal(err)
+		}
+		if stats := h.Stats(); stats.Hedges != 1 {
+			t.Errorf("expected the first fetch to be hedged, got %+v", stats)
+		}
+	})
+
+	subtest(t, "Failure", func(t *testing.T) {
+		injected := errors.New("injected failure")
+		sf := &laggingFetcher{memoryFetcher: newMemoryFetcher(64 * 16), fail: injected}
+		h := &HedgedFetcher{Fetcher: sf, Delay: time.Millisecond, MaxHedgeFraction: 1}
+
+		if _, err := h.FetchRanges([]ByteRange{{0, 15}}); err != injected {
+			t.Errorf("expected the injected failure, got %v", err)
+		}
+	})
+
+	subtest(t, "Reader", func(t *testing.T) {
+		sf := &laggingFetcher{memoryFetcher: newMemoryFetcher(64 * 16), stall: func(call int) bool { return call == 1 }}
+		r := &Reader{Fetcher: &HedgedFetcher{Fetcher: sf, Delay: 10 * time.Millisecond, MaxHedgeFraction: 1}, BlockSize: 16}
+
+		buf := make([]byte, 64)
+		if _, err := r.ReadAt(buf, 128); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, sf.data[128:192]) {
+			t.Error("data mismatch")
+		}
+	})
+}
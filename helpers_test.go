@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"fmt"
 	"io"
+	"sync"
 	"testing"
 )
 
@@ -90,3 +91,49 @@ func md5Sum(b []byte) string {
 	sum := md5.Sum(b)
 	return fmt.Sprintf("%02x", sum)
 }
+
+// memoryFetcher is a RangeFetcher over an in-memory buffer that records the ranges it is asked for.
+type memoryFetcher struct {
+	data []byte
+
+	mutex  sync.Mutex
+	calls  int
+	ranges []ByteRange
+}
+
+func newMemoryFetcher(size int) *memoryFetcher {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7 / 3)
+	}
+	return &memoryFetcher{data: data}
+}
+
+func (m *memoryFetcher) FetchRanges(ranges []ByteRange) ([]Block, error) {
+	m.mutex.Lock()
+	m.calls++
+	m.ranges = append(m.ranges, ranges...)
+	m.mutex.Unlock()
+
+	blox := make([]Block, len(ranges))
+	for i, rng := range ranges {
+		end := rng.End + 1
+		if end > int64(len(m.data)) {
+			end = int64(len(m.data))
+		}
+		blox[i].Length = end - rng.Start
+		blox[i].Data = append([]byte(nil), m.data[rng.Start:end]...)
+	}
+	return blox, nil
+}
+
+func (m *memoryFetcher) ExpectedLength() (int64, error) {
+	return int64(len(m.data)), nil
+}
+
+// counts returns the number of FetchRanges calls and ranges requested so far.
+func (m *memoryFetcher) counts() (calls, ranges int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.calls, len(m.ranges)
+}
@@ -1,6 +1,7 @@
 package ranger
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -8,8 +9,10 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const httpMethodGet = "GET"
@@ -18,6 +21,7 @@ const httpHeaderContentType = "Content-Type"
 const httpHeaderIfRange = "If-Range"
 const httpHeaderLastModified = "Last-Modified"
 const httpHeaderRange = "Range"
+const httpHeaderRetryAfter = "Retry-After"
 const mimeMultipartByteranges = "multipart/byteranges"
 
 // HTTPClient is an interface describing the methods required from net/http.Client
@@ -37,14 +41,56 @@ type HTTPRanger struct {
 	URL    *url.URL
 	Client HTTPClient
 
-	validator string
-	length    int64
+	// maximum number of distinct byte ranges to request at once; fetches requiring more are split across
+	// several requests. Zero means no limit.
+	MaxRanges int
 
-	once sync.Once
+	// maximum length of the Range header; fetches requiring a longer one are split across several requests.
+	// Many servers reject request headers longer than 8 KiB. Zero means no limit.
+	MaxRangeHeaderLength int
+
+	// policy for retrying requests that fail transiently, including the initial HEAD request; nil means that
+	// no request is retried. A Reader's own RetryPolicy retries whole fetches on top of this.
+	Retry *RetryPolicy
+
+	initMutex   sync.Mutex
+	initialized bool  // protected by initMutex
+	initErr     error // protected by initMutex
+	validator   string
+	length      int64
 }
 
-func statusCodeError(status int) error {
-	return fmt.Errorf("unexpected response (status %d)", status)
+// truncatedResponseError is returned when a response ends before all of the ranges requested in it have been read.
+type truncatedResponseError string
+
+func (e truncatedResponseError) Error() string {
+	return string(e)
+}
+
+func statusCodeError(resp *http.Response) error {
+	return &HTTPStatusError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: retryAfter(resp.Header.Get(httpHeaderRetryAfter)),
+	}
+}
+
+// retryAfter parses the value of a Retry-After header, which is either a number of seconds or a date.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(time.Now()); d > 0 {
+			return d
+		}
+	}
+	return 0
 }
 
 func statusIsAcceptable(status int) bool {
@@ -65,48 +111,92 @@ func validatorFromResponse(resp *http.Response) (string, error) {
 	return "", errors.New("no applicable validator in response")
 }
 
-// init performs a HEAD request to determine whether the resource is rangeable.
+// init performs a HEAD request to determine whether the resource is rangeable, as initContext does.
 func (r *HTTPRanger) init() error {
-	var outerErr error
-	r.once.Do(func() {
-		if r.Client == nil {
-			r.Client = &http.Client{}
-		}
+	return r.initContext(context.Background())
+}
+
+// initContext performs a HEAD request to determine whether the resource is rangeable, the first time it is called.
+// If ctx is done before the request is answered, the next call tries again; any other failure is returned from then on.
+func (r *HTTPRanger) initContext(ctx context.Context) error {
+	r.initMutex.Lock()
+	defer r.initMutex.Unlock()
+	if r.initialized {
+		return r.initErr
+	}
+
+	err := r.head(ctx)
+	if err != nil && ctx.Err() != nil {
+		// abandoned, rather than failed
+		return err
+	}
+	r.initialized, r.initErr = true, err
+	return err
+}
 
-		resp, err := r.Client.Head(r.URL.String())
+// head does the work of initContext.
+// invariant: r.initMutex is held
+func (r *HTTPRanger) head(ctx context.Context) error {
+	if r.Client == nil {
+		r.Client = &http.Client{}
+	}
+
+	var resp *http.Response
+	err := r.Retry.do(ctx, func() error {
+		req, err := http.NewRequest("HEAD", r.URL.String(), nil)
 		if err != nil {
-			outerErr = err
-			return
+			return err
 		}
-
+		resp, err = r.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
 		if !statusIsAcceptable(resp.StatusCode) {
-			outerErr = statusCodeError(resp.StatusCode)
-			return
+			return statusCodeError(resp)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		if !strings.Contains(resp.Header.Get(httpHeaderAcceptRanges), "bytes") {
-			outerErr = errors.New(r.URL.String() + " does not support byte-ranged requests.")
-			return
-		}
+	if !strings.Contains(resp.Header.Get(httpHeaderAcceptRanges), "bytes") {
+		return errors.New(r.URL.String() + " does not support byte-ranged requests.")
+	}
 
-		validator, err := validatorFromResponse(resp)
-		if err != nil {
-			outerErr = errors.New(r.URL.String() + " did not offer a strong-enough validator for subsequent requests")
-			return
-		}
+	validator, err := validatorFromResponse(resp)
+	if err != nil {
+		return errors.New(r.URL.String() + " did not offer a strong-enough validator for subsequent requests")
+	}
 
-		r.validator = validator
-		r.length = resp.ContentLength
-	})
-	return outerErr
+	r.validator = validator
+	r.length = resp.ContentLength
+	return nil
 }
 
 // ExpectedLength returns the length, in bytes, of the ranged-over file.
 func (r *HTTPRanger) ExpectedLength() (int64, error) {
-	err := r.init()
+	return r.ExpectedLengthContext(context.Background())
+}
+
+// ExpectedLengthContext returns the length, in bytes, of the ranged-over file, abandoning the HEAD request
+// made to determine it when ctx is done.
+func (r *HTTPRanger) ExpectedLengthContext(ctx context.Context) (int64, error) {
+	err := r.initContext(ctx)
 	return r.length, err
 }
 
+// Identity returns the URL of the ranged-over resource and the validator (its ETag or modification time)
+// against which all of its ranges are fetched.
+func (r *HTTPRanger) Identity() (string, string, error) {
+	err := r.init()
+	if err != nil {
+		return "", "", err
+	}
+	return r.URL.String(), r.validator, nil
+}
+
 func makeByteRangeHeader(ranges []ByteRange) string {
 	if len(ranges) > 0 {
 		ranges = coalesceAdjacentRanges(ranges)
@@ -128,7 +218,7 @@ func (r *HTTPRanger) validateResponse(resp *http.Response) error {
 	}
 
 	if !statusIsAcceptable(resp.StatusCode) {
-		return statusCodeError(resp.StatusCode)
+		return statusCodeError(resp)
 	}
 	newValidator, err := validatorFromResponse(resp)
 	if err != nil || newValidator != r.validator {
@@ -139,15 +229,167 @@ func (r *HTTPRanger) validateResponse(resp *http.Response) error {
 
 // FetchRanges requests ranges from the HTTP server.
 func (r *HTTPRanger) FetchRanges(ranges []ByteRange) ([]Block, error) {
+	return r.FetchRangesContext(context.Background(), ranges)
+}
+
+func byteRangeSpecLength(rng ByteRange) int {
+	return len(strconv.FormatInt(rng.Start, 10)) + 1 + len(strconv.FormatInt(rng.End, 10))
+}
+
+// splitRangesForRequests divides ranges into groups whose Range headers would contain no more than maxRanges
+// coalesced ranges and be no longer than maxHeaderLength, returned as the index of the first range in each group.
+// A group always contains at least one range.
+func splitRangesForRequests(ranges []ByteRange, maxRanges, maxHeaderLength int) []int {
+	var starts []int
+	var nranges, headerLen int
+	var last ByteRange
+	for i, rng := range ranges {
+		if i > 0 {
+			if rng.Start == last.End+1 {
+				// extends the last coalesced range
+				extended := ByteRange{last.Start, rng.End}
+				l := headerLen - byteRangeSpecLength(last) + byteRangeSpecLength(extended)
+				if maxHeaderLength <= 0 || l <= maxHeaderLength {
+					headerLen, last = l, extended
+					continue
+				}
+			} else {
+				l := headerLen + 1 + byteRangeSpecLength(rng)
+				if (maxRanges <= 0 || nranges < maxRanges) && (maxHeaderLength <= 0 || l <= maxHeaderLength) {
+					headerLen, last = l, rng
+					nranges++
+					continue
+				}
+			}
+		}
+
+		starts = append(starts, i)
+		headerLen = len("bytes=") + byteRangeSpecLength(rng)
+		nranges = 1
+		last = rng
+	}
+	return starts
+}
+
+// FetchRangesContext requests ranges from the HTTP server, canceling the request when ctx is done.
+// The ranges are requested in as few requests as MaxRanges and MaxRangeHeaderLength allow.
+func (r *HTTPRanger) FetchRangesContext(ctx context.Context, ranges []ByteRange) ([]Block, error) {
 	if len(ranges) == 0 {
 		return nil, nil
 	}
 
-	err := r.init()
+	err := r.initContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	return r.fetchRanges(ctx, ranges, nil, nil)
+}
+
+// FetchRangesInto requests ranges from the HTTP server, as FetchRangesContext does, reading the response
+// directly into dsts.
+func (r *HTTPRanger) FetchRangesInto(ctx context.Context, ranges []ByteRange, dsts [][]byte) error {
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	err := r.initContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	blox, err := r.fetchRanges(ctx, ranges, dsts, nil)
+	if err != nil {
+		return err
+	}
+	for i, b := range blox {
+		if int64(len(b.Data)) != b.Length {
+			return truncatedResponseError(fmt.Sprintf("http: expected %d bytes for range %d-%d, but only got %d", b.Length, ranges[i].Start, ranges[i].End, len(b.Data)))
+		}
+	}
+	return nil
+}
+
+// FetchRangesStreaming requests ranges from the HTTP server, as FetchRangesContext does, calling deliver with
+// each of them as soon as it has been read from the response.
+func (r *HTTPRanger) FetchRangesStreaming(ctx context.Context, ranges []ByteRange, deliver func(i int, b Block)) error {
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	err := r.initContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.fetchRanges(ctx, ranges, nil, deliver)
+	return err
+}
+
+// fetchRanges requests ranges from the HTTP server in as few requests as MaxRanges and MaxRangeHeaderLength allow.
+// If dsts is not nil, each range is read into the corresponding buffer in it. If deliver is not nil, it is called with
+// each range that is read in full, as soon as it has been.
+// invariant: after init()
+func (r *HTTPRanger) fetchRanges(ctx context.Context, ranges []ByteRange, dsts [][]byte, deliver func(i int, b Block)) ([]Block, error) {
+	starts := splitRangesForRequests(ranges, r.MaxRanges, r.MaxRangeHeaderLength)
+	if len(starts) == 1 {
+		return r.fetchRangesInRequest(ctx, ranges, dsts, deliver)
+	}
+
+	blox := make([]Block, 0, len(ranges))
+	for g, start := range starts {
+		end := len(ranges)
+		if g+1 < len(starts) {
+			end = starts[g+1]
+		}
+
+		var groupDsts [][]byte
+		if dsts != nil {
+			groupDsts = dsts[start:end]
+		}
+		var groupDeliver func(int, Block)
+		if deliver != nil {
+			first := start
+			groupDeliver = func(i int, b Block) { deliver(first+i, b) }
+		}
+		b, err := r.fetchRangesInRequest(ctx, ranges[start:end], groupDsts, groupDeliver)
+		if err != nil {
+			return nil, err
+		}
+		blox = append(blox, b...)
+	}
+	return blox, nil
+}
+
+// fetchRangesInRequest requests ranges from the HTTP server in a single request, as many times as r.Retry allows.
+// If dsts is not nil, each range is read into the corresponding buffer in it. If deliver is not nil, it is called with
+// each range that is read in full, as soon as it has been; no more than once, however many attempts are made.
+// invariant: after init()
+func (r *HTTPRanger) fetchRangesInRequest(ctx context.Context, ranges []ByteRange, dsts [][]byte, deliver func(i int, b Block)) (blox []Block, err error) {
+	if r.Retry != nil && deliver != nil {
+		delivered := make([]bool, len(ranges))
+		once := deliver
+		deliver = func(i int, b Block) {
+			if !delivered[i] {
+				delivered[i] = true
+				once(i, b)
+			}
+		}
+	}
+
+	err = r.Retry.do(ctx, func() error {
+		var err error
+		blox, err = r.fetchRangesInRequestOnce(ctx, ranges, dsts, deliver)
+		return err
+	})
+	return blox, err
+}
+
+// fetchRangesInRequestOnce requests ranges from the HTTP server in a single request.
+// If dsts is not nil, each range is read into the corresponding buffer in it. If deliver is not nil, it is called with
+// each range that is read in full, as soon as it has been.
+// invariant: after init()
+func (r *HTTPRanger) fetchRangesInRequestOnce(ctx context.Context, ranges []ByteRange, dsts [][]byte, deliver func(i int, b Block)) ([]Block, error) {
 	req := &http.Request{
 		Method: httpMethodGet,
 		URL:    r.URL,
@@ -156,6 +398,7 @@ func (r *HTTPRanger) FetchRanges(ranges []ByteRange) ([]Block, error) {
 			httpHeaderIfRange: []string{r.validator},
 		},
 	}
+	req = req.WithContext(ctx)
 
 	resp, err := r.Client.Do(req)
 	if err != nil {
@@ -177,14 +420,26 @@ func (r *HTTPRanger) FetchRanges(ranges []ByteRange) ([]Block, error) {
 	blox := make([]Block, len(ranges))
 	for i, v := range ranges {
 		blox[i].Length = v.End - v.Start + 1
+		if dsts != nil {
+			blox[i].Data = dsts[i]
+		}
+	}
+
+	var filled func(i int)
+	if deliver != nil {
+		filled = func(i int) {
+			if int64(len(blox[i].Data)) == blox[i].Length {
+				deliver(i, blox[i])
+			}
+		}
 	}
 
 	var n int
 	if typ == mimeMultipartByteranges {
 		multipart := multipart.NewReader(resp.Body, params["boundary"])
-		n, err = fillBlocksFromMultipartReader(blox, multipart)
+		n, err = fillBlocksFromMultipartReader(blox, multipart, filled)
 	} else {
-		n, err = fillBlocksFromContiguousReader(blox, resp.Body)
+		n, err = fillBlocksFromContiguousReader(blox, resp.Body, filled)
 	}
 
 	if err != nil {
@@ -192,13 +447,14 @@ func (r *HTTPRanger) FetchRanges(ranges []ByteRange) ([]Block, error) {
 	}
 
 	if n != len(blox) {
-		return nil, fmt.Errorf("http: expected to get %d content blocks back, but only got %d", len(blox), n)
+		return nil, truncatedResponseError(fmt.Sprintf("http: expected to get %d content blocks back, but only got %d", len(blox), n))
 	}
 
 	return blox, nil
 }
 
-func fillBlocksFromMultipartReader(blox []Block, mp *multipart.Reader) (c int, err error) {
+// fillBlocksFromMultipartReader fills blox from the parts of mp, calling filled (if it is not nil) with the index of each block as it is filled.
+func fillBlocksFromMultipartReader(blox []Block, mp *multipart.Reader, filled func(i int)) (c int, err error) {
 	for {
 		var p *multipart.Part
 		p, err = mp.NextPart()
@@ -206,8 +462,14 @@ func fillBlocksFromMultipartReader(blox []Block, mp *multipart.Reader) (c int, e
 			break
 		}
 
+		var partFilled func(int)
+		if filled != nil {
+			first := c
+			partFilled = func(i int) { filled(first + i) }
+		}
+
 		var n int
-		n, err = fillBlocksFromContiguousReader(blox[c:], p)
+		n, err = fillBlocksFromContiguousReader(blox[c:], p, partFilled)
 		if err != nil {
 			break
 		}
@@ -236,11 +498,15 @@ func readUntilErr(r io.Reader, p []byte) (c int, err error) {
 	return
 }
 
-func fillBlocksFromContiguousReader(blox []Block, r io.Reader) (c int, err error) {
+// fillBlocksFromContiguousReader fills blox from r, calling filled (if it is not nil) with the index of each block as it is filled.
+func fillBlocksFromContiguousReader(blox []Block, r io.Reader, filled func(i int)) (c int, err error) {
 	for i := range blox {
 		block := &blox[i]
 		l := block.Length
-		data := make([]byte, l)
+		data := block.Data // the destination provided by FetchRangesInto, if any
+		if data == nil {
+			data = make([]byte, l)
+		}
 
 		var n int
 		n, err = readUntilErr(r, data)
@@ -248,6 +514,9 @@ func fillBlocksFromContiguousReader(blox []Block, r io.Reader) (c int, err error
 			// Any data having been read dirties a block
 			block.Data = data[:n]
 			c++
+			if filled != nil {
+				filled(i)
+			}
 		}
 
 		if err != nil {
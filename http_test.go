@@ -1,8 +1,12 @@
 package ranger
 
 import (
+	"context"
+	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestFailureToConnect(t *testing.T) {
@@ -15,3 +19,100 @@ func TestFailureToConnect(t *testing.T) {
 		t.Log(err)
 	}
 }
+
+func TestFetchCanceled(t *testing.T) {
+	u, _ := url.Parse(testServer.URL + "/stalls")
+	r, err := NewReader(&HTTPRanger{URL: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	b := make([]byte, 16)
+	n, err := r.ReadAtContext(ctx, b, 0)
+	if err == nil {
+		t.Fatalf("read %d bytes", n)
+	} else if ctx.Err() == nil {
+		t.Fatalf("read failed before deadline: %v", err)
+	} else {
+		t.Log(err)
+	}
+}
+
+func TestSplitRangesForRequests(t *testing.T) {
+	ranges := []ByteRange{{0, 9}, {10, 19}, {100, 109}, {200, 209}, {1000, 1009}, {1010, 1019}}
+	if starts := splitRangesForRequests(ranges, 0, 0); !reflect.DeepEqual(starts, []int{0}) {
+		t.Errorf("unlimited: got %v", starts)
+	}
+	if starts := splitRangesForRequests(ranges, 2, 0); !reflect.DeepEqual(starts, []int{0, 3}) {
+		t.Errorf("two ranges per request: got %v", starts)
+	}
+
+	// "bytes=0-19,100-109" is 18 bytes long.
+	starts := splitRangesForRequests(ranges, 0, 18)
+	if !reflect.DeepEqual(starts, []int{0, 3, 4}) {
+		t.Errorf("18-byte headers: got %v", starts)
+	}
+	for i, start := range starts {
+		end := len(ranges)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		if l := len(makeByteRangeHeader(ranges[start:end])); l > 18 {
+			t.Errorf("header for group %d is %d bytes long", i, l)
+		}
+	}
+}
+
+func TestHTTPRangerSplitsRequests(t *testing.T) {
+	h := &flakyHandler{content: testServer.Config.Handler}
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/blocks/bl1")
+	hpr := &Reader{Fetcher: &HTTPRanger{URL: u, MaxRanges: 1}, BlockSize: 512}
+	err := hpr.Prefetch([]ByteRange{{0, 511}, {1024, 2047}, {4096, 4607}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gets := h.count(); gets != 3 {
+		t.Errorf("expected a request for each of the 3 ranges, got %d", gets)
+	}
+
+	cases := []TestCase{
+		&ReadAtTestCase{1024, 1024, "8a4653b85c77f911e9c1f2fdb8d19e87"},
+	}
+	for _, tc := range cases {
+		subtest(t, tc.Name(), func(t *testing.T) {
+			tc.RunTest(t, hpr)
+		})
+	}
+}
+
+func TestHTTPRangerInitHonorsContext(t *testing.T) {
+	u, _ := url.Parse(testServer.URL + "/stalls?head=stall")
+
+	subtest(t, "NewReaderContext", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := NewReaderContext(ctx, &HTTPRanger{URL: u}); err == nil {
+			t.Error("expected initialization to be abandoned")
+		}
+	})
+
+	subtest(t, "ReadAtContext", func(t *testing.T) {
+		r := &Reader{Fetcher: &HTTPRanger{URL: u}, BlockSize: 16}
+		for i := 0; i < 2; i++ {
+			// The second read must not find the Reader half-initialized by the first.
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			_, err := r.ReadAtContext(ctx, make([]byte, 16), 0)
+			expired := ctx.Err() != nil
+			cancel()
+			if err == nil || !expired {
+				t.Errorf("expected read %d to be abandoned along with initialization at its deadline, got %v", i, err)
+			}
+		}
+	})
+}
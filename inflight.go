@@ -0,0 +1,92 @@
+package ranger
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errFetchAbandoned is the error with which a fetch's claims are completed when whoever made it gave up on it
+// (as opposed to the fetch having failed.) Those waiting on it should claim and fetch the blocks themselves.
+var errFetchAbandoned = errors.New("fetch abandoned")
+
+// inflightBlock is a block that is being fetched. Its data and error are valid once done is closed.
+type inflightBlock struct {
+	done   chan struct{}
+	ticket *fetchTicket // the scheduled fetch that will complete it, if any
+	data   []byte
+	err    error
+}
+
+// inflightTable tracks the blocks that are currently being fetched so that concurrent
+// readers of the same block can share one fetch.
+type inflightTable struct {
+	mutex  sync.Mutex
+	blocks map[int]*inflightBlock
+}
+
+// claim sorts blocks that are not yet cached into those already being fetched by someone else, returned as waits,
+// and those that the caller must now fetch itself, returned as claimed. Blocks that became present in the cache since
+// the caller last checked are returned in present.
+// The caller must eventually complete every block in claimed, which it will fetch with ticket.
+func (t *inflightTable) claim(cache BlockCache, blockNumbers []int, ticket *fetchTicket) (claimed []int, waits map[int]*inflightBlock, present []int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.blocks == nil {
+		t.blocks = make(map[int]*inflightBlock)
+	}
+
+	for _, bn := range blockNumbers {
+		if f, ok := t.blocks[bn]; ok {
+			if waits == nil {
+				waits = make(map[int]*inflightBlock)
+			}
+			waits[bn] = f
+			continue
+		}
+
+		// The block may have been completed (and cached) between the caller's cache lookup and now.
+		if cache.Has(bn) {
+			present = append(present, bn)
+			continue
+		}
+
+		t.blocks[bn] = &inflightBlock{done: make(chan struct{}), ticket: ticket}
+		claimed = append(claimed, bn)
+	}
+	return
+}
+
+// lookup returns the in-flight fetch of block bn, which must have been claimed and not yet completed.
+func (t *inflightTable) lookup(bn int) *inflightBlock {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.blocks[bn]
+}
+
+// complete releases everyone waiting on block bn.
+func (t *inflightTable) complete(bn int, data []byte, err error) {
+	t.mutex.Lock()
+	f := t.blocks[bn]
+	delete(t.blocks, bn)
+	t.mutex.Unlock()
+
+	f.data, f.err = data, err
+	close(f.done)
+}
+
+// wait waits for f to complete or ctx to be done.
+func (f *inflightBlock) wait(ctx context.Context) ([]byte, error) {
+	select {
+	case <-f.done:
+		return f.data, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// isContextError reports whether err is the result of a context having been canceled or having exceeded its deadline.
+func isContextError(err error) bool {
+	return err == context.Canceled || err == context.DeadlineExceeded
+}
@@ -0,0 +1,194 @@
+package ranger
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedFetcher is a memoryFetcher whose fetches of ranges starting at gate do not return until release is closed.
+type gatedFetcher struct {
+	*memoryFetcher
+	gate    int64
+	release chan struct{}
+}
+
+func (g *gatedFetcher) FetchRanges(ranges []ByteRange) ([]Block, error) {
+	for _, rng := range ranges {
+		if rng.Start == g.gate {
+			<-g.release
+		}
+	}
+	return g.memoryFetcher.FetchRanges(ranges)
+}
+
+func TestConcurrentReadersShareFetch(t *testing.T) {
+	f := &gatedFetcher{newMemoryFetcher(64 * 16), 0, make(chan struct{})}
+	r := &Reader{Fetcher: f, BlockSize: 16}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := make([]byte, 8)
+			if _, err := r.ReadAt(b, 4); err != nil {
+				t.Error(err)
+			}
+			if !bytes.Equal(b, f.data[4:12]) {
+				t.Error("data mismatch")
+			}
+		}()
+	}
+
+	// Give the readers time to pile up on the same block.
+	time.Sleep(20 * time.Millisecond)
+	close(f.release)
+	wg.Wait()
+
+	if _, n := f.counts(); n != 1 {
+		t.Errorf("expected one fetch for concurrent readers of one block, got %d", n)
+	}
+}
+
+func TestStalledFetchDoesNotBlockOthers(t *testing.T) {
+	f := &gatedFetcher{newMemoryFetcher(64 * 16), 0, make(chan struct{})}
+	defer close(f.release)
+	r := &Reader{Fetcher: f, BlockSize: 16}
+
+	b := make([]byte, 8)
+	if _, err := r.ReadAt(b, 32); err != nil {
+		t.Fatal(err)
+	}
+
+	go r.ReadAt(make([]byte, 8), 0)
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// one cache hit, one uncached block
+		r.ReadAt(b, 32)
+		r.ReadAt(b, 48)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reads of unrelated blocks waited on a stalled fetch")
+	}
+}
+
+func TestWaiterSurvivesAbandonedFetch(t *testing.T) {
+	f := &gatedFetcher{newMemoryFetcher(64 * 16), 0, make(chan struct{})}
+	r := &Reader{Fetcher: f, BlockSize: 16}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	owner := make(chan error)
+	go func() {
+		_, err := r.ReadAtContext(ctx, make([]byte, 8), 0)
+		owner <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	waiter := make(chan error)
+	b := make([]byte, 8)
+	go func() {
+		_, err := r.ReadAt(b, 0)
+		waiter <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	if err := <-owner; err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	close(f.release)
+	if err := <-waiter; err != nil {
+		t.Fatalf("waiter failed because another reader gave up: %v", err)
+	}
+	if !bytes.Equal(b, f.data[0:8]) {
+		t.Error("data mismatch")
+	}
+}
+
+// stallingFailure fails requests by never answering them, until they are canceled.
+func stallingFailure(http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+}
+
+// Over HTTP, an abandoned fetch fails with the client's own error, wrapping the context's.
+func TestWaiterSurvivesAbandonedHTTPFetch(t *testing.T) {
+	abandon := func(t *testing.T, r *Reader, h *flakyHandler, data []byte, start func() func()) {
+		giveUp := start()
+		for h.count() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		waiter := make(chan error)
+		b := make([]byte, 8)
+		go func() {
+			_, err := r.ReadAt(b, 0)
+			waiter <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		giveUp()
+		if err := <-waiter; err != nil {
+			t.Fatalf("waiter failed because another reader gave up: %v", err)
+		}
+		if !bytes.Equal(b, data[0:8]) {
+			t.Error("data mismatch")
+		}
+	}
+
+	for _, extents := range []bool{false, true} {
+		name := "Blocks"
+		if extents {
+			name = "Extents"
+		}
+
+		subtest(t, name+"/PrefetchCanceled", func(t *testing.T) {
+			s, h, data := newFlakyServer(1, stallingFailure)
+			defer s.Close()
+			u, _ := url.Parse(s.URL)
+			r := &Reader{Fetcher: &HTTPRanger{URL: u}, BlockSize: 64, Extents: extents}
+
+			abandon(t, r, h, data, func() func() {
+				p := r.PrefetchAsync([]ByteRange{{0, 63}})
+				return func() {
+					p.Cancel()
+					_ = p.Wait()
+				}
+			})
+		})
+
+		subtest(t, name+"/DeadlineExceeded", func(t *testing.T) {
+			s, h, data := newFlakyServer(1, stallingFailure)
+			defer s.Close()
+			u, _ := url.Parse(s.URL)
+			r := &Reader{Fetcher: &HTTPRanger{URL: u}, BlockSize: 64, Extents: extents}
+
+			abandon(t, r, h, data, func() func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				owner := make(chan error)
+				go func() {
+					_, err := r.ReadAtContext(ctx, make([]byte, 8), 0)
+					owner <- err
+				}()
+				return func() {
+					defer cancel()
+					if err := <-owner; err == nil {
+						t.Error("expected the reader whose deadline passed to fail")
+					}
+				}
+			})
+		})
+	}
+}
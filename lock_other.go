@@ -0,0 +1,75 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package ranger
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// staleLockAge is how long a lock file must have gone untouched before it is assumed to have been left behind by a
+// process that crashed while holding it. Holders touch their lock files well within it, however long they hold them.
+const staleLockAge = 30 * time.Second
+
+// lockFile takes a lock on the file at path by exclusively creating a sibling file.
+// Platforms without flock(2) cannot share a lock, so every lock is exclusive.
+// A sibling file that has gone untouched for staleLockAge is broken, and the lock taken anyway.
+// It returns a function that releases the lock.
+func lockFile(path string, exclusive bool) (func(), error) {
+	path += ".excl"
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			_ = f.Close()
+			return holdLockFile(path), nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > staleLockAge {
+			breakLockFile(path, fi)
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// holdLockFile touches the lock file at path until the returned function, which releases the lock, is called.
+func holdLockFile(path string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(staleLockAge / 4)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				_ = os.Chtimes(path, now, now)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		_ = os.Remove(path)
+	}
+}
+
+// breakLockFile removes the lock file at path, if it is still the stale one described by stale.
+// Renaming it first ensures that a lock taken since by someone else is never removed in its place.
+func breakLockFile(path string, stale os.FileInfo) {
+	aside := fmt.Sprintf("%s.%d.%d", path, os.Getpid(), time.Now().UnixNano())
+	if os.Rename(path, aside) != nil {
+		// someone else got to it first
+		return
+	}
+	if fi, err := os.Stat(aside); err == nil && !os.SameFile(fi, stale) {
+		// It was replaced by a live lock after we looked; put that back, unless yet another has been taken since.
+		_ = os.Link(aside, path)
+	}
+	_ = os.Remove(aside)
+}
@@ -0,0 +1,202 @@
+package ranger
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultFetchConcurrency is the default number of concurrent fetches issued by a ParallelFetcher.
+const DefaultFetchConcurrency = 4
+
+// ParallelFetcher is a RangeFetcher that splits each fetch into several smaller ones, each covering one contiguous
+// run of bytes, and issues up to Concurrency of them at once through Fetcher. Over HTTP, each of these becomes
+// a single-range request on its own connection or HTTP/2 stream.
+//
+// The ranges passed to FetchRanges are never themselves split, so a fetch may exceed SplitSize when
+// a single range does.
+type ParallelFetcher struct {
+	// the fetcher through which the split fetches are made
+	Fetcher RangeFetcher
+
+	// maximum number of bytes to request in each fetch; zero means that the bytes of each call are divided evenly,
+	// to the nearest whole range, among Concurrency fetches
+	SplitSize int64
+
+	// maximum number of fetches outstanding at once, across all calls; defaults to DefaultFetchConcurrency
+	Concurrency int
+
+	once sync.Once
+	sem  chan struct{}
+}
+
+// ExpectedLength returns the length, in bytes, of the ranged-over source.
+func (p *ParallelFetcher) ExpectedLength() (int64, error) {
+	return p.Fetcher.ExpectedLength()
+}
+
+// ExpectedLengthContext returns the length, in bytes, of the ranged-over source, abandoning the attempt to
+// determine it when ctx is done if the underlying fetcher supports that.
+func (p *ParallelFetcher) ExpectedLengthContext(ctx context.Context) (int64, error) {
+	return expectedLength(ctx, p.Fetcher)
+}
+
+// Identity returns the identity of the underlying fetcher's resource, if it has one.
+func (p *ParallelFetcher) Identity() (string, string, error) {
+	return identityOf(p.Fetcher)
+}
+
+// FetchRanges fetches ranges concurrently.
+func (p *ParallelFetcher) FetchRanges(ranges []ByteRange) ([]Block, error) {
+	return p.FetchRangesContext(context.Background(), ranges)
+}
+
+// splitRanges divides ranges into groups of contiguous ranges covering no more than splitSize bytes each,
+// returned as the index of the first range in each group
+func splitRanges(ranges []ByteRange, splitSize int64) []int {
+	var starts []int
+	var size int64
+	for i, rng := range ranges {
+		l := rng.End - rng.Start + 1
+		if i == 0 || rng.Start != ranges[i-1].End+1 || (splitSize > 0 && size+l > splitSize) {
+			starts = append(starts, i)
+			size = 0
+		}
+		size += l
+	}
+	return starts
+}
+
+// concurrency returns the maximum number of fetches outstanding at once.
+func (p *ParallelFetcher) concurrency() int {
+	if p.Concurrency <= 0 {
+		return DefaultFetchConcurrency
+	}
+	return p.Concurrency
+}
+
+// split divides ranges into groups for splitRanges, by p.SplitSize or, if it is zero, into a group for each of
+// p.concurrency() fetches.
+func (p *ParallelFetcher) split(ranges []ByteRange) []int {
+	size := p.SplitSize
+	if size <= 0 {
+		var total int64
+		for _, rng := range ranges {
+			total += rng.End - rng.Start + 1
+		}
+		c := int64(p.concurrency())
+		size = (total + c - 1) / c
+	}
+	return splitRanges(ranges, size)
+}
+
+// FetchRangesContext fetches ranges concurrently, abandoning all outstanding fetches when ctx is done or any one of them fails.
+func (p *ParallelFetcher) FetchRangesContext(ctx context.Context, ranges []ByteRange) ([]Block, error) {
+	fetcher := ContextFetcher(p.Fetcher)
+	starts := p.split(ranges)
+	if len(starts) <= 1 {
+		return fetcher.FetchRangesContext(ctx, ranges)
+	}
+
+	blox := make([]Block, len(ranges))
+	err := p.fanOut(ctx, len(ranges), starts, func(ctx context.Context, start, end int) error {
+		b, err := fetcher.FetchRangesContext(ctx, ranges[start:end])
+		if err != nil {
+			return err
+		}
+		if len(b) != end-start {
+			return errors.New("parallel: fetcher returned the wrong number of blocks")
+		}
+		copy(blox[start:end], b)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blox, nil
+}
+
+// FetchRangesInto fetches ranges concurrently into dsts, as FetchRangesContext does. Each split fetch is made
+// directly into dsts if the underlying fetcher supports it.
+func (p *ParallelFetcher) FetchRangesInto(ctx context.Context, ranges []ByteRange, dsts [][]byte) error {
+	fetcher := ContextFetcher(p.Fetcher)
+	starts := p.split(ranges)
+	if len(starts) <= 1 {
+		return fetchRangesInto(ctx, fetcher, ranges, dsts)
+	}
+
+	return p.fanOut(ctx, len(ranges), starts, func(ctx context.Context, start, end int) error {
+		return fetchRangesInto(ctx, fetcher, ranges[start:end], dsts[start:end])
+	})
+}
+
+// FetchRangesStreaming fetches ranges concurrently, as FetchRangesContext does, calling deliver with each of them
+// as soon as the fetch including it has made it available.
+func (p *ParallelFetcher) FetchRangesStreaming(ctx context.Context, ranges []ByteRange, deliver func(i int, b Block)) error {
+	fetcher := ContextFetcher(p.Fetcher)
+	starts := p.split(ranges)
+	if len(starts) <= 1 {
+		return fetchRangesStreaming(ctx, fetcher, ranges, deliver)
+	}
+
+	var mutex sync.Mutex
+	return p.fanOut(ctx, len(ranges), starts, func(ctx context.Context, start, end int) error {
+		return fetchRangesStreaming(ctx, fetcher, ranges[start:end], func(i int, b Block) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			deliver(start+i, b)
+		})
+	})
+}
+
+// fanOut calls fetch for each group of the n ranges beginning at starts, with up to p.Concurrency calls outstanding at once,
+// and returns the first error any of them returns. The context passed to fetch is canceled once any of them fails.
+func (p *ParallelFetcher) fanOut(ctx context.Context, n int, starts []int, fetch func(ctx context.Context, start, end int) error) error {
+	p.once.Do(func() {
+		p.sem = make(chan struct{}, p.concurrency())
+	})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	for g, start := range starts {
+		end := n
+		if g+1 < len(starts) {
+			end = starts[g+1]
+		}
+
+		acquired := false
+		select {
+		case p.sem <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+		if !acquired {
+			fail(ctx.Err())
+			break
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			defer func() { <-p.sem }()
+
+			if err := fetch(ctx, start, end); err != nil {
+				fail(err)
+			}
+		}(start, end)
+	}
+
+	wg.Wait()
+	return firstErr
+}
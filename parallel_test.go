@@ -0,0 +1,116 @@
+package ranger
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSplitRanges(t *testing.T) {
+	ranges := []ByteRange{{0, 9}, {10, 19}, {20, 29}, {40, 49}, {50, 59}, {70, 79}}
+	if starts := splitRanges(ranges, 0); !reflect.DeepEqual(starts, []int{0, 3, 5}) {
+		t.Errorf("unlimited split: got %v", starts)
+	}
+	if starts := splitRanges(ranges, 20); !reflect.DeepEqual(starts, []int{0, 2, 3, 5}) {
+		t.Errorf("split at 20 bytes: got %v", starts)
+	}
+}
+
+// concurrencyFetcher is a memoryFetcher that records the greatest number of fetches it has seen outstanding at once.
+type concurrencyFetcher struct {
+	*memoryFetcher
+
+	mutex             sync.Mutex
+	current, maxSeen  int
+	failOnRangeStarts int64
+}
+
+func (c *concurrencyFetcher) FetchRanges(ranges []ByteRange) ([]Block, error) {
+	c.mutex.Lock()
+	c.current++
+	if c.current > c.maxSeen {
+		c.maxSeen = c.current
+	}
+	c.mutex.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	c.mutex.Lock()
+	c.current--
+	c.mutex.Unlock()
+
+	if c.failOnRangeStarts > 0 && ranges[0].Start == c.failOnRangeStarts {
+		return nil, errors.New("injected failure")
+	}
+	return c.memoryFetcher.FetchRanges(ranges)
+}
+
+func TestParallelFetcher(t *testing.T) {
+	cf := &concurrencyFetcher{memoryFetcher: newMemoryFetcher(64 * 16)}
+	r := &Reader{Fetcher: &ParallelFetcher{Fetcher: cf, SplitSize: 64, Concurrency: 3}, BlockSize: 16}
+
+	buf := make([]byte, 64*16)
+	if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, cf.data) {
+		t.Fatal("data mismatch")
+	}
+
+	if calls, _ := cf.counts(); calls != 16 {
+		t.Errorf("expected 16 split fetches, got %d", calls)
+	}
+	if cf.maxSeen != 3 {
+		t.Errorf("expected 3 concurrent fetches, saw %d", cf.maxSeen)
+	}
+}
+
+func TestParallelFetcherDefaultSplit(t *testing.T) {
+	// A single read of a contiguous run of missing blocks is divided among the fetches.
+	cf := &concurrencyFetcher{memoryFetcher: newMemoryFetcher(64 * 16)}
+	r := &Reader{Fetcher: &ParallelFetcher{Fetcher: cf, Concurrency: 4}, BlockSize: 16}
+
+	buf := make([]byte, 64*16)
+	if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, cf.data) {
+		t.Fatal("data mismatch")
+	}
+
+	if calls, _ := cf.counts(); calls != 4 {
+		t.Errorf("expected 4 split fetches, got %d", calls)
+	}
+	if cf.maxSeen != 4 {
+		t.Errorf("expected 4 concurrent fetches, saw %d", cf.maxSeen)
+	}
+}
+
+func TestParallelFetcherFailure(t *testing.T) {
+	cf := &concurrencyFetcher{memoryFetcher: newMemoryFetcher(64 * 16), failOnRangeStarts: 256}
+	r := &Reader{Fetcher: &ParallelFetcher{Fetcher: cf, SplitSize: 64}, BlockSize: 16}
+
+	buf := make([]byte, 64*16)
+	if _, err := r.ReadAt(buf, 0); err == nil {
+		t.Fatal("expected a failed split fetch to fail the read")
+	}
+}
+
+func TestParallelFetcherHTTP(t *testing.T) {
+	u, _ := url.Parse(testServer.URL + "/blocks/bl1")
+	cases := []TestCase{
+		&ReadAtTestCase{0, 5120, "a32f7f07d7a54d59ed310aa4f79a6b93"},
+	}
+
+	hpr := &Reader{Fetcher: &ParallelFetcher{Fetcher: &HTTPRanger{URL: u}, SplitSize: 1024}, BlockSize: 512}
+	for _, tc := range cases {
+		subtest(t, tc.Name(), func(t *testing.T) {
+			tc.RunTest(t, hpr)
+		})
+	}
+}
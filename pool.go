@@ -0,0 +1,104 @@
+package ranger
+
+import "sync"
+
+// BufferPool is a source of reusable buffers for block data, which may be shared by any number of Readers.
+//
+// Get returns a buffer of length size, whose contents are undefined.
+//
+// Put returns buf to the pool. Its caller must not use buf again.
+type BufferPool interface {
+	Get(size int) []byte
+	Put(buf []byte)
+}
+
+// SizedBufferPool is a BufferPool that keeps a sync.Pool for each distinct buffer size, such as
+// the block sizes of the Readers sharing it.
+type SizedBufferPool struct {
+	mutex sync.Mutex
+	pools map[int]*sync.Pool
+}
+
+// NewBufferPool returns an empty SizedBufferPool.
+func NewBufferPool() *SizedBufferPool {
+	return &SizedBufferPool{
+		pools: make(map[int]*sync.Pool),
+	}
+}
+
+func (p *SizedBufferPool) pool(size int) *sync.Pool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sp, ok := p.pools[size]
+	if !ok {
+		sp = &sync.Pool{
+			New: func() interface{} {
+				b := make([]byte, size)
+				return &b
+			},
+		}
+		p.pools[size] = sp
+	}
+	return sp
+}
+
+// Get returns a buffer of length size, reusing one that was put back if there is one.
+func (p *SizedBufferPool) Get(size int) []byte {
+	return (*p.pool(size).Get().(*[]byte))[:size]
+}
+
+// Put makes buf available to be returned by Get for its capacity.
+func (p *SizedBufferPool) Put(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
+	buf = buf[:cap(buf)]
+	p.pool(cap(buf)).Put(&buf)
+}
+
+// borrowBlockBuffers returns buffers from r.BufferPool into which to fetch ranges, each covering no more than one block.
+// invariant: after init(); r.borrowsBuffers()
+func (r *Reader) borrowBlockBuffers(ranges []ByteRange) [][]byte {
+	dsts := make([][]byte, len(ranges))
+	for i, rng := range ranges {
+		dsts[i] = r.BufferPool.Get(r.BlockSize)[:rng.End-rng.Start+1]
+	}
+	return dsts
+}
+
+// borrowsBuffers reports whether blocks are fetched into buffers borrowed from r.BufferPool: only if r.Cache
+// will return them to it.
+// invariant: after init()
+func (r *Reader) borrowsBuffers() bool {
+	mc, ok := r.Cache.(*MemoryCache)
+	return ok && r.BufferPool != nil && mc.Pool == r.BufferPool
+}
+
+// holdBlocks pins the given blocks, if their buffers may be returned to a pool once they are evicted from the cache,
+// so that they can be copied from safely. The returned function releases them.
+// invariant: after init()
+func (r *Reader) holdBlocks(blockNumbers []int) func() {
+	pc, ok := r.Cache.(PinningCache)
+	if !ok || !r.cacheRecycles() {
+		return func() {}
+	}
+
+	for _, bn := range blockNumbers {
+		pc.Pin(bn)
+	}
+	return func() {
+		for _, bn := range blockNumbers {
+			pc.Unpin(bn)
+		}
+	}
+}
+
+// cacheRecycles reports whether r.Cache may return the buffers of the blocks it evicts to a pool.
+// invariant: after init()
+func (r *Reader) cacheRecycles() bool {
+	if mc, ok := r.Cache.(*MemoryCache); ok && mc.Pool != nil {
+		return true
+	}
+	return r.BufferPool != nil
+}
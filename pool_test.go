@@ -0,0 +1,162 @@
+package ranger
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// countingPool is a SizedBufferPool that counts the buffers borrowed from and returned to it.
+type countingPool struct {
+	*SizedBufferPool
+
+	mutex      sync.Mutex
+	gets, puts int
+}
+
+func (c *countingPool) Get(size int) []byte {
+	c.mutex.Lock()
+	c.gets++
+	c.mutex.Unlock()
+	return c.SizedBufferPool.Get(size)
+}
+
+func (c *countingPool) Put(buf []byte) {
+	c.mutex.Lock()
+	c.puts++
+	c.mutex.Unlock()
+	c.SizedBufferPool.Put(buf)
+}
+
+func TestSizedBufferPool(t *testing.T) {
+	p := NewBufferPool()
+	b := p.Get(16)
+	if len(b) != 16 {
+		t.Errorf("expected a 16-byte buffer, got %d", len(b))
+	}
+	p.Put(b[:5])
+	if b = p.Get(32); len(b) != 32 {
+		t.Errorf("expected a 32-byte buffer, got %d", len(b))
+	}
+}
+
+func TestReaderBufferPool(t *testing.T) {
+	f := newMemoryFetcher(64*16 + 5)
+	pool := &countingPool{SizedBufferPool: NewBufferPool()}
+	r := &Reader{Fetcher: f, BlockSize: 16, CacheSize: 64, BufferPool: pool}
+
+	for i := 0; i < 4; i++ {
+		b := make([]byte, 100)
+		off := int64(i * 200)
+		if _, err := r.ReadAt(b, off); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, f.data[off:off+100]) {
+			t.Errorf("data mismatch at %d", off)
+		}
+	}
+
+	b := make([]byte, 5)
+	r.ReadAt(b, 64*16)
+	if !bytes.Equal(b, f.data[64*16:]) {
+		t.Error("data mismatch in the final, short block")
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.gets != 4*7+1 {
+		t.Errorf("expected a buffer to be borrowed for each of the %d blocks fetched, got %d", 4*7+1, pool.gets)
+	}
+	if held := pool.gets - pool.puts; int64(held*16) > 64 {
+		t.Errorf("expected evicted blocks to be returned to the pool; %d are still held", held)
+	}
+}
+
+func TestReaderBufferPoolConcurrent(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	pool := NewBufferPool()
+
+	// Two Readers sharing a pool, each with a cache too small for its working set
+	readers := []*Reader{
+		{Fetcher: f, BlockSize: 16, CacheSize: 48, BufferPool: pool},
+		{Fetcher: f, BlockSize: 16, CacheSize: 48, BufferPool: pool, Coalesce: &CoalescePolicy{RangeOverhead: 32, GapByteCost: 1}},
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for i := 0; i < 200; i++ {
+				r := readers[rnd.Intn(len(readers))]
+				off := rnd.Int63n(int64(len(f.data) - 40))
+				b := make([]byte, 1+rnd.Intn(40))
+				if _, err := r.ReadAt(b, off); err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(b, f.data[off:off+int64(len(b))]) {
+					t.Errorf("data mismatch at %d", off)
+					return
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+}
+
+func TestReaderHoldsBlocksOfRecyclingCache(t *testing.T) {
+	// The cache recycles evicted blocks even though the Reader has no BufferPool of its own.
+	mc := NewMemoryCache(48)
+	mc.Pool = NewBufferPool()
+	r := &Reader{Fetcher: newMemoryFetcher(64 * 16), BlockSize: 16, Cache: mc}
+	if _, err := r.ReadAt(make([]byte, 1), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	release := r.holdBlocks([]int{0, 1})
+	mc.mutex.Lock()
+	pins := mc.pins[0] + mc.pins[1]
+	mc.mutex.Unlock()
+	release()
+	if pins != 2 {
+		t.Errorf("expected the blocks being copied from to be pinned, got %d pins", pins)
+	}
+}
+
+func TestReaderBufferPoolHTTP(t *testing.T) {
+	u, _ := url.Parse(testServer.URL + "/blocks/bl1")
+	hpr := &Reader{Fetcher: &HTTPRanger{URL: u}, BlockSize: 512, CacheSize: 1024, BufferPool: NewBufferPool()}
+
+	tc := &ReadAtTestCase{1024, 1024, "8a4653b85c77f911e9c1f2fdb8d19e87"}
+	tc.RunTest(t, hpr)
+
+	// Evict everything, recycling the blocks' buffers, and read again.
+	hpr.ReadAt(make([]byte, 5120), 0)
+	hpr.ReadAt(make([]byte, 2048), 3072)
+	tc.RunTest(t, hpr)
+}
+
+func TestReaderBufferPoolCompressed(t *testing.T) {
+	f := newTextFetcher(64 * 16)
+	pool := &countingPool{SizedBufferPool: NewBufferPool()}
+	r := &Reader{Fetcher: f, BlockSize: 16, CacheSize: 64, CompressCache: true, BufferPool: pool}
+
+	b := make([]byte, len(f.data))
+	if _, err := r.ReadAt(b, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, f.data) {
+		t.Error("data mismatch")
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if held := pool.gets - pool.puts; held != 0 {
+		t.Errorf("expected no buffers to be kept from the pool by a compressed cache; %d are", held)
+	}
+}
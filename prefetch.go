@@ -0,0 +1,134 @@
+package ranger
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// ErrPrefetchCanceled is returned by PendingPrefetch.Wait when the prefetch was canceled before it completed.
+var ErrPrefetchCanceled = errors.New("prefetch canceled")
+
+// Prefetch loads the blocks covering the given ranges into the cache, fetching all of those that are
+// not already cached in a single request. Ranges are clamped to the bounds of the ranged-over source.
+// Subsequent reads within the ranges will be served from the cache (as long as it has not evicted them.)
+func (r *Reader) Prefetch(ranges []ByteRange) error {
+	return r.PrefetchContext(context.Background(), ranges)
+}
+
+// PrefetchContext loads the blocks covering the given ranges into the cache, as Prefetch does,
+// abandoning the fetch when ctx is done.
+func (r *Reader) PrefetchContext(ctx context.Context, ranges []ByteRange) error {
+	err := r.initContext(ctx)
+	if err != nil {
+		return err
+	}
+	return r.prefetchRanges(withFetchPriority(ctx, priorityPrefetch), ranges, r.statSinks(nil))
+}
+
+// prefetchRanges loads the given ranges into the cache.
+// invariant: after init()
+func (r *Reader) prefetchRanges(ctx context.Context, ranges []ByteRange, st statSinks) error {
+	if r.Extents {
+		var wants []span
+		for _, rng := range ranges {
+			if rng, ok := r.clampRange(rng); ok {
+				wants = append(wants, span{rng.Start, rng.End + 1})
+			}
+		}
+		_, err := r.loadExtents(ctx, wants, make([][]byte, len(wants)), st)
+		return err
+	}
+
+	blockNumbers := r.blocksForRanges(ranges)
+	if len(blockNumbers) == 0 {
+		return nil
+	}
+
+	_, err := r.loadBlocks(ctx, blockNumbers, st)
+	return err
+}
+
+// clampRange returns rng limited to the bounds of the ranged-over source, and whether anything remains of it.
+// invariant: after init()
+func (r *Reader) clampRange(rng ByteRange) (ByteRange, bool) {
+	if rng.Start < 0 {
+		rng.Start = 0
+	}
+	if rng.End >= r.len {
+		rng.End = r.len - 1
+	}
+	return rng, rng.Start <= rng.End
+}
+
+// blocksForRanges returns the sorted, distinct indices of the blocks covering ranges
+// invariant: after init()
+func (r *Reader) blocksForRanges(ranges []ByteRange) []int {
+	seen := make(map[int]struct{})
+	var blockNumbers []int
+	for _, rng := range ranges {
+		rng, ok := r.clampRange(rng)
+		if !ok {
+			continue
+		}
+
+		startBlock, nblocks := blockRange(rng.Start, int(rng.End-rng.Start+1), r.BlockSize)
+		for i := 0; i < nblocks; i++ {
+			bn := startBlock + i
+			if _, ok := seen[bn]; !ok {
+				seen[bn] = struct{}{}
+				blockNumbers = append(blockNumbers, bn)
+			}
+		}
+	}
+	sort.Ints(blockNumbers)
+	return blockNumbers
+}
+
+// PendingPrefetch is a prefetch started by PrefetchAsync.
+type PendingPrefetch struct {
+	done   chan struct{}
+	err    error // valid once done is closed
+	cancel context.CancelFunc
+}
+
+// PrefetchAsync begins loading the blocks covering the given ranges into the cache in the background,
+// as Prefetch does.
+func (r *Reader) PrefetchAsync(ranges []ByteRange) *PendingPrefetch {
+	return r.PrefetchAsyncContext(context.Background(), ranges)
+}
+
+// PrefetchAsyncContext begins loading the blocks covering the given ranges into the cache in the background,
+// as Prefetch does. The prefetch is canceled when ctx is done.
+func (r *Reader) PrefetchAsyncContext(ctx context.Context, ranges []ByteRange) *PendingPrefetch {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &PendingPrefetch{
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+
+	go func() {
+		defer close(p.done)
+		defer cancel()
+		err := r.PrefetchContext(ctx, ranges)
+		if err != nil && ctx.Err() != nil {
+			// The fetcher's error may only wrap the context's.
+			err = ErrPrefetchCanceled
+		}
+		p.err = err
+	}()
+
+	return p
+}
+
+// Wait blocks until the prefetch has completed or been canceled, and returns the error, if any.
+func (p *PendingPrefetch) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Cancel abandons the prefetch, including any fetch that it has already begun.
+// Blocks that had already been fetched remain cached.
+func (p *PendingPrefetch) Cancel() {
+	p.cancel()
+}
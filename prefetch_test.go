@@ -0,0 +1,70 @@
+package ranger
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPrefetch(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16}
+
+	ranges := []ByteRange{{0, 20}, {100, 130}, {1000, 2000}, {-5, 3}}
+	if err := r.Prefetch(ranges); err != nil {
+		t.Fatal(err)
+	}
+	calls, nranges := f.counts()
+	if calls != 1 {
+		t.Errorf("expected prefetch to make one request, made %d", calls)
+	}
+	if nranges != 2+3+2 {
+		t.Errorf("expected prefetch to request 7 blocks, requested %d", nranges)
+	}
+
+	b := make([]byte, 24)
+	r.ReadAt(b, 100)
+	if c, _ := f.counts(); c != calls {
+		t.Error("read of prefetched region made a request")
+	}
+	if !bytes.Equal(b, f.data[100:124]) {
+		t.Error("data mismatch in prefetched region")
+	}
+}
+
+func TestPrefetchAsync(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16}
+
+	p := r.PrefetchAsync([]ByteRange{{32, 63}})
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Cache.Has(2) || !r.Cache.Has(3) {
+		t.Error("async prefetch did not populate the cache")
+	}
+
+	// Hold up fetching so that the prefetch cannot complete before it is canceled.
+	sf := &stallingFetcher{f, make(chan struct{})}
+	defer close(sf.release)
+	r = &Reader{Fetcher: sf, BlockSize: 16}
+	p = r.PrefetchAsync([]ByteRange{{128, 200}})
+	p.Cancel()
+	err := p.Wait()
+	if err != ErrPrefetchCanceled {
+		t.Errorf("expected %v, got %v", ErrPrefetchCanceled, err)
+	}
+}
+
+func TestPrefetchAsyncCanceledOverHTTP(t *testing.T) {
+	u, _ := url.Parse(testServer.URL + "/stalls")
+	r := &Reader{Fetcher: &HTTPRanger{URL: u}, BlockSize: 16}
+
+	p := r.PrefetchAsync([]ByteRange{{0, 15}})
+	time.Sleep(20 * time.Millisecond) // for the request to be made
+	p.Cancel()
+	if err := p.Wait(); err != ErrPrefetchCanceled {
+		t.Errorf("expected %v, got %v", ErrPrefetchCanceled, err)
+	}
+}
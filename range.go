@@ -1,5 +1,10 @@
 package ranger
 
+import (
+	"context"
+	"errors"
+)
+
 // RangeFetcher is the interface that wraps the FetchBlocks method.
 //
 // FetchBlocks fetches the specified block ranges and returns any errors encountered in doing so.
@@ -12,6 +17,147 @@ type RangeFetcher interface {
 	ExpectedLength() (int64, error)
 }
 
+// ContextLengthFetcher is a RangeFetcher that must do something that can be canceled, such as make a request,
+// to determine the length of its source.
+type ContextLengthFetcher interface {
+	RangeFetcher
+	ExpectedLengthContext(ctx context.Context) (int64, error)
+}
+
+// expectedLength returns the length of f's source, abandoning the attempt to determine it when ctx is done
+// if f supports that.
+func expectedLength(ctx context.Context, f RangeFetcher) (int64, error) {
+	if lf, ok := f.(ContextLengthFetcher); ok {
+		return lf.ExpectedLengthContext(ctx)
+	}
+	return f.ExpectedLength()
+}
+
+// ContextRangeFetcher is a RangeFetcher whose fetches can be canceled or bounded by a deadline.
+//
+// FetchRangesContext fetches the specified byte ranges, as FetchRanges does, and abandons the fetch
+// when ctx is done, returning ctx's error.
+type ContextRangeFetcher interface {
+	RangeFetcher
+	FetchRangesContext(ctx context.Context, ranges []ByteRange) ([]Block, error)
+}
+
+// DirectRangeFetcher is a ContextRangeFetcher that can fetch into buffers provided by its caller, sparing
+// the allocation and copy of the blocks that FetchRanges would return.
+//
+// FetchRangesInto fetches each of the specified byte ranges into the corresponding buffer in dsts, which is
+// exactly as long as the range. It returns an error if any range could not be fetched in full.
+type DirectRangeFetcher interface {
+	ContextRangeFetcher
+	FetchRangesInto(ctx context.Context, ranges []ByteRange, dsts [][]byte) error
+}
+
+// errShortFetch is returned when a fetcher returns fewer blocks, or less data, than it was asked for.
+var errShortFetch = errors.New("fetcher returned less data than requested")
+
+// checkBlocks returns errShortFetch unless blox holds the whole of each of ranges.
+func checkBlocks(ranges []ByteRange, blox []Block) error {
+	if len(blox) < len(ranges) {
+		return errShortFetch
+	}
+	for i, rng := range ranges {
+		if int64(len(blox[i].Data)) != rng.End-rng.Start+1 {
+			return errShortFetch
+		}
+	}
+	return nil
+}
+
+// fetchRangesInto fetches ranges into dsts through f; directly, if f is a DirectRangeFetcher.
+func fetchRangesInto(ctx context.Context, f ContextRangeFetcher, ranges []ByteRange, dsts [][]byte) error {
+	if df, ok := f.(DirectRangeFetcher); ok {
+		return df.FetchRangesInto(ctx, ranges, dsts)
+	}
+
+	blox, err := f.FetchRangesContext(ctx, ranges)
+	if err != nil {
+		return err
+	}
+	if err = checkBlocks(ranges, blox); err != nil {
+		return err
+	}
+	for i := range ranges {
+		copy(dsts[i], blox[i].Data)
+	}
+	return nil
+}
+
+// StreamingRangeFetcher is a ContextRangeFetcher that can hand over each range as soon as it has been fetched,
+// rather than once they all have.
+//
+// FetchRangesStreaming fetches the specified byte ranges, as FetchRangesContext does, calling deliver with the index
+// and contents of each range as soon as it has been fetched in full. deliver is called at most once for each range,
+// and never concurrently; if FetchRangesStreaming returns an error, it may not have been called for every range.
+type StreamingRangeFetcher interface {
+	ContextRangeFetcher
+	FetchRangesStreaming(ctx context.Context, ranges []ByteRange, deliver func(i int, b Block)) error
+}
+
+// fetchRangesStreaming fetches ranges through f, delivering each as soon as f makes it available.
+func fetchRangesStreaming(ctx context.Context, f ContextRangeFetcher, ranges []ByteRange, deliver func(i int, b Block)) error {
+	if sf, ok := f.(StreamingRangeFetcher); ok {
+		return sf.FetchRangesStreaming(ctx, ranges, deliver)
+	}
+
+	blox, err := f.FetchRangesContext(ctx, ranges)
+	if err != nil {
+		return err
+	}
+	for i, b := range blox {
+		if i < len(ranges) {
+			deliver(i, b)
+		}
+	}
+	return nil
+}
+
+// ContextFetcher returns f as a ContextRangeFetcher. If f does not implement ContextRangeFetcher itself,
+// it is wrapped such that its fetches return early when their context is done; the underlying FetchRanges
+// call runs to completion in the background and its results are discarded.
+func ContextFetcher(f RangeFetcher) ContextRangeFetcher {
+	if cf, ok := f.(ContextRangeFetcher); ok {
+		return cf
+	}
+	return contextFetcherAdapter{f}
+}
+
+type contextFetcherAdapter struct {
+	RangeFetcher
+}
+
+type fetchResult struct {
+	blox []Block
+	err  error
+}
+
+func (a contextFetcherAdapter) FetchRangesContext(ctx context.Context, ranges []ByteRange) ([]Block, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if ctx.Done() == nil {
+		// this context can never be canceled
+		return a.FetchRanges(ranges)
+	}
+
+	ch := make(chan fetchResult, 1)
+	go func() {
+		blox, err := a.FetchRanges(ranges)
+		ch <- fetchResult{blox, err}
+	}()
+
+	select {
+	case res := <-ch:
+		return res.blox, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 // Block represents a block returned from a ranged read
 type Block struct {
 	Length int64
@@ -23,6 +169,44 @@ type ByteRange struct {
 	Start, End int64
 }
 
+// CoalescePolicy decides when a Reader should also fetch the blocks lying in the gap between two
+// blocks it needs, so that the two can be requested as one range instead of two. The blocks in the gap
+// are cached along with the rest.
+//
+// A gap is filled when the cost of the bytes in it is no greater than the cost of requesting another range.
+type CoalescePolicy struct {
+	// estimated cost of each additional range in a request, in bytes: its share of the response headers and
+	// multipart boundaries, and of the server's per-range work
+	RangeOverhead int64
+
+	// cost of each byte read only to fill a gap, relative to the bytes that were needed; zero means 1
+	GapByteCost float64
+}
+
+// fillsGap reports whether the policy would fill a gap of n bytes.
+func (p *CoalescePolicy) fillsGap(n int64) bool {
+	cost := p.GapByteCost
+	if cost <= 0 {
+		cost = 1
+	}
+	return float64(n)*cost <= float64(p.RangeOverhead)
+}
+
+// gapBlocks returns the indices of the blocks in the gaps between the given sorted blocks that p would fill
+func (p *CoalescePolicy) gapBlocks(blockNumbers []int, blockSize int) []int {
+	var gaps []int
+	for i := 1; i < len(blockNumbers); i++ {
+		prev, next := blockNumbers[i-1], blockNumbers[i]
+		if next-prev <= 1 || !p.fillsGap(int64(next-prev-1)*int64(blockSize)) {
+			continue
+		}
+		for bn := prev + 1; bn < next; bn++ {
+			gaps = append(gaps, bn)
+		}
+	}
+	return gaps
+}
+
 // blockRange returns the starting block and number of full blocks covered by a byte range at the given block size
 func blockRange(off int64, length int, blockSize int) (int, int) {
 	startBlock := int(off / int64(blockSize))
@@ -0,0 +1,84 @@
+package ranger
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stallingFetcher is a RangeFetcher whose fetches do not return until release is closed.
+type stallingFetcher struct {
+	*memoryFetcher
+	release chan struct{}
+}
+
+func (s *stallingFetcher) FetchRanges(ranges []ByteRange) ([]Block, error) {
+	<-s.release
+	return s.memoryFetcher.FetchRanges(ranges)
+}
+
+func TestContextFetcherAdapter(t *testing.T) {
+	f := &stallingFetcher{newMemoryFetcher(1024), make(chan struct{})}
+	defer close(f.release)
+
+	r := &Reader{Fetcher: f, BlockSize: 16}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	b := make([]byte, 16)
+	_, err := r.ReadAtContext(ctx, b, 0)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// A canceled fetch must not prevent others from proceeding.
+	_, err = r.ReadAtContext(ctx, b, 16)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestCoalesceAdjacentRanges(t *testing.T) {
+	ranges := coalesceAdjacentRanges([]ByteRange{{0, 9}, {10, 19}, {30, 39}, {40, 49}, {60, 69}})
+	expected := []ByteRange{{0, 19}, {30, 49}, {60, 69}}
+	if len(ranges) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, ranges)
+	}
+	for i := range ranges {
+		if ranges[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, ranges)
+		}
+	}
+}
+
+func TestCoalescePolicy(t *testing.T) {
+	p := &CoalescePolicy{RangeOverhead: 32}
+	gaps := p.gapBlocks([]int{1, 3, 5, 9, 10}, 16)
+	expected := []int{2, 4}
+	if !reflect.DeepEqual(gaps, expected) {
+		t.Errorf("expected gap blocks %v, got %v", expected, gaps)
+	}
+
+	p.GapByteCost = 4
+	if gaps := p.gapBlocks([]int{1, 3}, 16); len(gaps) != 0 {
+		t.Errorf("expected expensive gap bytes not to be filled, got %v", gaps)
+	}
+}
+
+func TestReaderFillsGaps(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, Coalesce: &CoalescePolicy{RangeOverhead: 16}}
+
+	if err := r.Prefetch([]ByteRange{{16, 31}, {48, 63}, {80, 95}, {160, 175}}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ByteRange{{16, 31}, {32, 47}, {48, 63}, {64, 79}, {80, 95}, {160, 175}}
+	if !reflect.DeepEqual(f.ranges, expected) {
+		t.Errorf("expected ranges %v, got %v", expected, f.ranges)
+	}
+	if !r.Cache.Has(2) || !r.Cache.Has(4) {
+		t.Error("gap blocks were not cached")
+	}
+}
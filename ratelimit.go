@@ -0,0 +1,234 @@
+package ranger
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a rate limit: tokens accrue at rate per second, up to burst, and are spent by whatever is limited.
+type tokenBucket struct {
+	rate   float64 // zero means unlimited
+	burst  float64
+	tokens float64 // may be negative, after something larger than burst has been allowed
+	last   time.Time
+}
+
+// set changes the rate and burst of the bucket, keeping the tokens it has accrued (up to the new burst.)
+func (b *tokenBucket) set(rate, burst float64, now time.Time) {
+	b.refill(now)
+	if burst < 1 {
+		burst = 1
+	}
+	if b.rate <= 0 {
+		// Nothing was spent while there was no limit.
+		b.tokens = burst
+	}
+	b.rate, b.burst = rate, burst
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+}
+
+// refill adds the tokens accrued since the bucket was last refilled.
+func (b *tokenBucket) refill(now time.Time) {
+	if b.rate > 0 && !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// wait returns how long it will be until n tokens may be taken: until as many have accrued, or, if n is more than
+// burst, until the bucket is full.
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	if n > b.burst {
+		n = b.burst
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take spends n tokens.
+func (b *tokenBucket) take(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
+
+// RateLimiter limits the rate of the fetches made through any number of RateLimitedFetchers, in bytes per second and
+// in requests per second, each with a burst allowance. Its limits can be changed at any time, including while
+// fetches are waiting on them.
+//
+// A fetch larger than the byte burst is allowed once the bucket is full, and leaves it in debt: later fetches wait
+// until the debt has been paid off.
+//
+// The zero RateLimiter imposes no limits until some are set.
+type RateLimiter struct {
+	mutex    sync.Mutex
+	bytes    tokenBucket
+	requests tokenBucket
+	changed  chan struct{} // closed whenever the limits change; made by the first fetch to wait on it
+}
+
+// NewRateLimiter returns a RateLimiter allowing bytesPerSecond bytes per second, in bursts of up to byteBurst bytes,
+// and requestsPerSecond requests per second, in bursts of up to requestBurst requests. A rate of zero means no limit.
+func NewRateLimiter(bytesPerSecond float64, byteBurst int64, requestsPerSecond float64, requestBurst int) *RateLimiter {
+	l := &RateLimiter{}
+	now := time.Now()
+	l.bytes.set(bytesPerSecond, float64(byteBurst), now)
+	l.requests.set(requestsPerSecond, float64(requestBurst), now)
+	return l
+}
+
+// SetByteRate changes the limit on bytes fetched to bytesPerSecond, in bursts of up to burst bytes.
+// A rate of zero means no limit.
+func (l *RateLimiter) SetByteRate(bytesPerSecond float64, burst int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.bytes.set(bytesPerSecond, float64(burst), time.Now())
+	l.wakeLocked()
+}
+
+// SetRequestRate changes the limit on requests made to requestsPerSecond, in bursts of up to burst requests.
+// A rate of zero means no limit.
+func (l *RateLimiter) SetRequestRate(requestsPerSecond float64, burst int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.requests.set(requestsPerSecond, float64(burst), time.Now())
+	l.wakeLocked()
+}
+
+// ByteRate returns the limit on bytes fetched, in bytes per second, and its burst.
+func (l *RateLimiter) ByteRate() (float64, int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.bytes.rate, int64(l.bytes.burst)
+}
+
+// RequestRate returns the limit on requests made, in requests per second, and its burst.
+func (l *RateLimiter) RequestRate() (float64, int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.requests.rate, int(l.requests.burst)
+}
+
+// invariant: l.mutex is held
+func (l *RateLimiter) wakeLocked() {
+	if l.changed != nil {
+		close(l.changed)
+		l.changed = nil
+	}
+}
+
+// wait waits until a request for n bytes is allowed, and counts it; or until ctx is done.
+func (l *RateLimiter) wait(ctx context.Context, n int64) error {
+	for {
+		l.mutex.Lock()
+		now := time.Now()
+		l.bytes.refill(now)
+		l.requests.refill(now)
+
+		d := l.bytes.wait(float64(n))
+		if rd := l.requests.wait(1); rd > d {
+			d = rd
+		}
+		if d <= 0 {
+			l.bytes.take(float64(n))
+			l.requests.take(1)
+			l.mutex.Unlock()
+			return nil
+		}
+		if l.changed == nil {
+			l.changed = make(chan struct{})
+		}
+		changed := l.changed
+		l.mutex.Unlock()
+
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// RateLimitedFetcher is a RangeFetcher whose fetches through Fetcher wait for Limiter to allow them. Each fetch
+// counts as one request, and as many bytes as it covers.
+type RateLimitedFetcher struct {
+	// the fetcher through which fetches are made
+	Fetcher RangeFetcher
+
+	// the limits that fetches are subject to; it may be shared with other RateLimitedFetchers. nil means no limit.
+	Limiter *RateLimiter
+}
+
+// ExpectedLength returns the length, in bytes, of the ranged-over source.
+func (f *RateLimitedFetcher) ExpectedLength() (int64, error) {
+	return f.Fetcher.ExpectedLength()
+}
+
+// ExpectedLengthContext returns the length, in bytes, of the ranged-over source, abandoning the attempt to
+// determine it when ctx is done if the underlying fetcher supports that.
+func (f *RateLimitedFetcher) ExpectedLengthContext(ctx context.Context) (int64, error) {
+	return expectedLength(ctx, f.Fetcher)
+}
+
+// Identity returns the identity of the underlying fetcher's resource, if it has one.
+func (f *RateLimitedFetcher) Identity() (string, string, error) {
+	return identityOf(f.Fetcher)
+}
+
+// FetchRanges fetches ranges once the limiter allows it.
+func (f *RateLimitedFetcher) FetchRanges(ranges []ByteRange) ([]Block, error) {
+	return f.FetchRangesContext(context.Background(), ranges)
+}
+
+// FetchRangesContext fetches ranges once the limiter allows it, abandoning the wait, or the fetch, when ctx is done.
+func (f *RateLimitedFetcher) FetchRangesContext(ctx context.Context, ranges []ByteRange) ([]Block, error) {
+	if err := f.wait(ctx, ranges); err != nil {
+		return nil, err
+	}
+	return ContextFetcher(f.Fetcher).FetchRangesContext(ctx, ranges)
+}
+
+// FetchRangesInto fetches ranges into dsts, as FetchRangesContext does, directly if the underlying fetcher supports it.
+func (f *RateLimitedFetcher) FetchRangesInto(ctx context.Context, ranges []ByteRange, dsts [][]byte) error {
+	if err := f.wait(ctx, ranges); err != nil {
+		return err
+	}
+	return fetchRangesInto(ctx, ContextFetcher(f.Fetcher), ranges, dsts)
+}
+
+// FetchRangesStreaming fetches ranges, as FetchRangesContext does, calling deliver with each of them as soon as the
+// underlying fetcher makes it available.
+func (f *RateLimitedFetcher) FetchRangesStreaming(ctx context.Context, ranges []ByteRange, deliver func(i int, b Block)) error {
+	if err := f.wait(ctx, ranges); err != nil {
+		return err
+	}
+	return fetchRangesStreaming(ctx, ContextFetcher(f.Fetcher), ranges, deliver)
+}
+
+// wait waits for the limiter, if there is one, to allow a fetch of ranges.
+func (f *RateLimitedFetcher) wait(ctx context.Context, ranges []ByteRange) error {
+	if f.Limiter == nil {
+		return nil
+	}
+
+	var n int64
+	for _, rng := range ranges {
+		n += rng.End - rng.Start + 1
+	}
+	return f.Limiter.wait(ctx, n)
+}
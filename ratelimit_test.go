@@ -0,0 +1,173 @@
+package ranger
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	var b tokenBucket
+	b.set(100, 50, now)
+
+	if d := b.wait(50); d != 0 {
+		t.Errorf("expected a full bucket, got a wait of %v", d)
+	}
+	b.take(50)
+	if d := b.wait(10); d != 100*time.Millisecond {
+		t.Errorf("expected to wait 100ms for 10 tokens, got %v", d)
+	}
+
+	// A request larger than the burst waits only for the bucket to fill, and then leaves it in debt.
+	b.refill(now.Add(time.Second))
+	if d := b.wait(200); d != 0 {
+		t.Errorf("expected an oversized request to be allowed from a full bucket, got a wait of %v", d)
+	}
+	b.take(200)
+	if d := b.wait(1); d != 1510*time.Millisecond {
+		t.Errorf("expected to wait 1.51s to pay off the debt, got %v", d)
+	}
+
+	// Lifting the limit lifts the debt.
+	b.set(0, 50, now.Add(time.Second))
+	if d := b.wait(1000); d != 0 {
+		t.Errorf("expected no wait without a limit, got %v", d)
+	}
+	b.set(100, 50, now.Add(time.Second))
+	if b.tokens != 50 {
+		t.Errorf("expected a full bucket once limited again, got %v tokens", b.tokens)
+	}
+}
+
+func TestRateLimitedFetcher(t *testing.T) {
+	subtest(t, "Bytes", func(t *testing.T) {
+		mf := newMemoryFetcher(64 * 16)
+		l := NewRateLimiter(16*100, 16, 0, 0)
+		r := &Reader{Fetcher: &RateLimitedFetcher{Fetcher: mf, Limiter: l}, BlockSize: 16}
+
+		start := time.Now()
+		b := make([]byte, 16)
+		for i := int64(0); i < 6; i++ {
+			if _, err := r.ReadAt(b, i*16); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, mf.data[i*16:i*16+16]) {
+				t.Errorf("data mismatch at %d", i*16)
+			}
+		}
+		// The first block comes from the burst, and each of the rest takes 10ms.
+		if d := time.Since(start); d < 45*time.Millisecond {
+			t.Errorf("expected 6 blocks at 100 blocks per second to take at least 50ms, took %v", d)
+		}
+	})
+
+	subtest(t, "Requests", func(t *testing.T) {
+		mf := newMemoryFetcher(64 * 16)
+		l := NewRateLimiter(0, 0, 100, 2)
+		f := &RateLimitedFetcher{Fetcher: mf, Limiter: l}
+
+		start := time.Now()
+		for i := 0; i < 6; i++ {
+			if _, err := f.FetchRanges([]ByteRange{{0, 15}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if d := time.Since(start); d < 35*time.Millisecond {
+			t.Errorf("expected 6 requests at 100 per second, in bursts of 2, to take at least 40ms, took %v", d)
+		}
+	})
+
+	subtest(t, "Shared", func(t *testing.T) {
+		l := NewRateLimiter(0, 0, 100, 1)
+		var wg sync.WaitGroup
+		start := time.Now()
+		for i := 0; i < 2; i++ {
+			mf := newMemoryFetcher(64 * 16)
+			r := &Reader{Fetcher: &RateLimitedFetcher{Fetcher: mf, Limiter: l}, BlockSize: 16}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b := make([]byte, 16)
+				for off := int64(0); off < 64; off += 16 {
+					if _, err := r.ReadAt(b, off); err != nil {
+						t.Error(err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		// Eight fetches between them, at 100 per second.
+		if d := time.Since(start); d < 65*time.Millisecond {
+			t.Errorf("expected the Readers to share the limit, but they took only %v", d)
+		}
+	})
+
+	subtest(t, "ChangedWhileWaiting", func(t *testing.T) {
+		mf := newMemoryFetcher(64 * 16)
+		l := NewRateLimiter(1, 1, 0, 0)
+		f := &RateLimitedFetcher{Fetcher: mf, Limiter: l}
+
+		// The first fetch is allowed, but leaves the limiter a minute in debt.
+		if _, err := f.FetchRanges([]ByteRange{{0, 63}}); err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := f.FetchRanges([]ByteRange{{64, 127}})
+			done <- err
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		l.SetByteRate(0, 0)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected lifting the limit to release the waiting fetch")
+		}
+		if rate, _ := l.ByteRate(); rate != 0 {
+			t.Errorf("expected no byte limit, got %v", rate)
+		}
+	})
+
+	subtest(t, "ZeroValue", func(t *testing.T) {
+		var l RateLimiter
+		f := &RateLimitedFetcher{Fetcher: newMemoryFetcher(64 * 16), Limiter: &l}
+		if _, err := f.FetchRanges([]ByteRange{{0, 15}}); err != nil {
+			t.Fatal(err)
+		}
+
+		l.SetRequestRate(1, 1)
+		l.SetByteRate(1000, 100)
+		if rate, burst := l.RequestRate(); rate != 1 || burst != 1 {
+			t.Errorf("expected a limit of 1 request per second, got %v in bursts of %v", rate, burst)
+		}
+	})
+
+	subtest(t, "Canceled", func(t *testing.T) {
+		mf := newMemoryFetcher(64 * 16)
+		l := NewRateLimiter(0, 0, 1, 1)
+		f := &RateLimitedFetcher{Fetcher: mf, Limiter: l}
+
+		if _, err := f.FetchRanges([]ByteRange{{0, 15}}); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := f.FetchRangesContext(ctx, []ByteRange{{0, 15}}); err != context.DeadlineExceeded {
+			t.Errorf("expected the wait to be abandoned, got %v", err)
+		}
+		if calls, _ := mf.counts(); calls != 1 {
+			t.Errorf("expected 1 fetch, got %d", calls)
+		}
+	})
+}
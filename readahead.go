@@ -0,0 +1,79 @@
+package ranger
+
+import (
+	"context"
+	"sync"
+)
+
+// readaheadState tracks sequential reads through a Reader and the blocks already requested ahead of them.
+type readaheadState struct {
+	mutex sync.Mutex
+
+	next   int64 // offset at which the next sequential read would begin
+	window int   // number of blocks to keep loaded ahead of the cursor
+	end    int   // first block not yet requested by readahead
+	busy   bool  // whether a readahead fetch is outstanding
+}
+
+// reset forgets any detected sequential access, such as after a seek to off.
+func (s *readaheadState) reset(off int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.next = off
+	s.window = 0
+	s.end = 0
+}
+
+// readahead records a read of n bytes at off in the stream tracked by s and, if reading has been sequential, starts fetching the blocks
+// ahead of it in the background. The readahead window starts at one block and doubles on every subsequent
+// sequential read, up to r.Readahead blocks.
+// invariant: after init()
+func (r *Reader) readahead(s *readaheadState, off, n int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sequential := off == s.next
+	s.next = off + n
+	if !sequential || s.next >= r.len {
+		s.window = 0
+		s.end = 0
+		return
+	}
+
+	if s.window == 0 {
+		s.window = 1
+	} else {
+		s.window *= 2
+	}
+	if s.window > r.Readahead {
+		s.window = r.Readahead
+	}
+
+	start := int(s.next / int64(r.BlockSize))
+	if s.end > start {
+		start = s.end
+	}
+	end := int(s.next/int64(r.BlockSize)) + s.window
+	if lastBlock := int((r.len - 1) / int64(r.BlockSize)); end > lastBlock+1 {
+		end = lastBlock + 1
+	}
+
+	if s.busy || start >= end {
+		return
+	}
+
+	s.busy = true
+	s.end = end
+	go func() {
+		// Errors are otherwise ignored here: a subsequent Read will encounter them itself.
+		err := r.prefetchRanges(withFetchPriority(context.Background(), priorityReadahead), []ByteRange{{r.blockByteRange(start).Start, r.blockByteRange(end - 1).End}}, r.statSinks(nil))
+
+		s.mutex.Lock()
+		s.busy = false
+		if err != nil && s.end == end {
+			// The blocks may not have been fetched, such as when a read preempted the fetch; request them again next time.
+			s.end = start
+		}
+		s.mutex.Unlock()
+	}()
+}
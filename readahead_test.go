@@ -0,0 +1,92 @@
+package ranger
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// waitForReadahead waits for any background readahead on r to finish.
+func waitForReadahead(t *testing.T, r *Reader) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.cursor.ra.mutex.Lock()
+		busy := r.cursor.ra.busy
+		r.cursor.ra.mutex.Unlock()
+		if !busy {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("readahead did not finish")
+}
+
+func TestReadaheadSequential(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, Readahead: 8}
+
+	var out bytes.Buffer
+	b := make([]byte, 16)
+	for i := 0; i < 4; i++ {
+		n, _ := r.Read(b)
+		out.Write(b[:n])
+		waitForReadahead(t, r)
+	}
+
+	if r.cursor.ra.window != 8 {
+		t.Errorf("expected readahead window to grow to 8 blocks, got %d", r.cursor.ra.window)
+	}
+	for i := 4; i < 4+8; i++ {
+		if !r.Cache.Has(i) {
+			t.Errorf("block %d was not read ahead", i)
+		}
+	}
+
+	_, before := f.counts()
+	for i := 0; i < 8; i++ {
+		n, _ := r.Read(b)
+		out.Write(b[:n])
+		waitForReadahead(t, r)
+	}
+	for {
+		n, err := r.Read(b)
+		out.Write(b[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitForReadahead(t, r)
+	}
+
+	if !bytes.Equal(out.Bytes(), f.data) {
+		t.Error("data mismatch while streaming with readahead")
+	}
+	if _, after := f.counts(); after-before != 64-12 {
+		t.Errorf("expected %d blocks to be fetched after the window filled, got %d", 64-12, after-before)
+	}
+}
+
+func TestReadaheadResetsOnSeek(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, Readahead: 8}
+
+	b := make([]byte, 16)
+	for i := 0; i < 3; i++ {
+		r.Read(b)
+		waitForReadahead(t, r)
+	}
+
+	r.Seek(40*16, io.SeekStart)
+	if r.cursor.ra.window != 0 {
+		t.Errorf("expected seek to reset the readahead window, got %d", r.cursor.ra.window)
+	}
+
+	r.Read(b)
+	waitForReadahead(t, r)
+	if r.cursor.ra.window != 1 || !r.Cache.Has(41) || r.Cache.Has(42) {
+		t.Error("expected readahead of a single block after a seek")
+	}
+}
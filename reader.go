@@ -1,35 +1,152 @@
 package ranger
 
 import (
+	"context"
 	"errors"
 	"io"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // DefaultBlockSize is the default size for the blocks that are downloaded from the server and cached.
 const DefaultBlockSize int = 128 * 1024
 
-// Reader is an io.ReaderAt and io.ReadSeeker backed by a partial block store.
+// Reader is an io.ReaderAt, io.ReadSeeker and io.WriterTo backed by a partial block store.
 type Reader struct {
+	// The counters are updated atomically; they must stay first, where they are 64-bit aligned even on 32-bit platforms.
+	stats statCounters
+
 	// the range fetcher with which to download blocks
 	Fetcher RangeFetcher
 
 	// size of the blocks fetched from the source and cached; lower values translate to lower memory usage, but typically require more requests
 	BlockSize int
 
-	once sync.Once
-	len  int64 // protected by once
-
-	mutex  sync.RWMutex
-	off    int64
-	blocks map[int][]byte
+	// the store for fetched blocks; if nil, a MemoryCache limited to CacheSize bytes and CacheBlocks blocks is used
+	Cache BlockCache
+
+	// maximum number of bytes of block data to keep in the default cache; once it is exceeded, the least-recently-used unpinned blocks
+	// are evicted and will be fetched again when next needed. Zero means no limit.
+	CacheSize int64
+
+	// maximum number of blocks to keep in the default cache, whatever their size, evicting as CacheSize does;
+	// zero means no limit. Both limits apply if both are set. Ignored in extent mode.
+	CacheBlocks int
+
+	// whether the default cache stores blocks compressed, so that CacheSize bytes hold far more of a compressible source;
+	// each block is decompressed every time it is read. See CompressedCache.
+	CompressCache bool
+
+	// whether to store fetched data as variable-size extents rather than as fixed-size blocks; in extent mode,
+	// reads fetch exactly the intervals that are missing (extended to at least MinFetchSize bytes), Cache is unused,
+	// and CacheSize limits the extents held
+	Extents bool
+
+	// in extent mode, the minimum number of bytes fetched for each missing interval; defaults to DefaultMinFetchSize
+	MinFetchSize int64
+
+	// the pool from which to borrow the buffers for fetched blocks; if it is set, the default cache returns them
+	// to it once they are evicted. Fetchers that implement DirectRangeFetcher fetch straight into the borrowed buffers.
+	// Buffers are borrowed only for a MemoryCache whose Pool is this pool, as the default cache's is: a compressed
+	// cache, like any other, keeps none of the buffers put into it, so none are borrowed for it.
+	// Ignored in extent mode.
+	BufferPool BufferPool
+
+	// the memory budget, shared with other Readers, against which blocks being fetched are counted, and to which the default
+	// cache is attached; fetches wait while the budget is exhausted. Ignored in extent mode.
+	Budget *Budget
+
+	// the policy for fetching the gaps between needed blocks along with them; if nil, only directly adjacent
+	// blocks are requested together
+	Coalesce *CoalescePolicy
+
+	// whether to size fetches adaptively: when reads are sequential, fetches are extended beyond the data being read to
+	// the bandwidth-delay product of the link, as measured from previous fetches. Each Cursor and Section is its own
+	// stream of reads, as are calls to ReadAt. Scattered reads fetch only the bytes they need: in extent mode these are
+	// cached as usual, while otherwise, when fetching the whole blocks they lie in would cost more than a round trip,
+	// they are fetched alone and not cached, so that the cache only ever holds whole blocks.
+	Adaptive bool
+
+	// maximum number of bytes an adaptively-sized fetch may cover; defaults to DefaultMaxAdaptiveFetch
+	MaxAdaptiveFetch int64
+
+	// maximum number of blocks that Read fetches ahead of the cursor, in the background, once it detects
+	// sequential reading; zero disables readahead
+	Readahead int
+
+	// minimum length of a read for it to be made directly: the whole blocks that it covers and that are not cached
+	// are fetched straight into the caller's buffer without being cached, and only the partial blocks at either end
+	// are read through the cache. Zero disables direct reads. Ignored in extent mode.
+	DirectReadSize int64
+
+	// how long to collect the blocks missed by concurrent reads before fetching them all together, in one request; zero disables
+	// batching. Each read is released as soon as its own blocks arrive. A batched fetch is not abandoned when the reads waiting on it are.
+	BatchWindow time.Duration
+
+	// policy for retrying fetches that fail transiently; nil means that no fetch is retried
+	Retry *RetryPolicy
+
+	// maximum number of fetches to have outstanding at once. Fetches beyond it wait, and are started in order of priority:
+	// those that reads are blocked on first, then explicit prefetches, then readahead; a read that finds no room preempts a
+	// running readahead fetch. Zero means no limit.
+	MaxFetches int
+
+	// maximum number of prefetches, and separately of readahead fetches, that may wait for one of MaxFetches; a prefetch
+	// beyond it fails with ErrFetchQueueFull, and readahead is skipped. Reads always wait. Zero means no limit.
+	MaxQueuedFetches int
+
+	// number of fetches that WriteTo keeps in flight ahead of its writer; defaults to DefaultStreamDepth
+	StreamDepth int
+
+	// number of bytes that WriteTo fetches at a time, rounded up to a whole number of blocks; defaults to DefaultStreamChunkSize
+	StreamChunkSize int64
+
+	// whether WriteTo fetches directly, neither consulting nor populating the cache, so that streaming
+	// the source through it does not evict everything else
+	StreamUncached bool
+
+	initMutex   sync.Mutex
+	initialized uint32              // set atomically, under initMutex, once init has finished
+	initErr     error               // set by init
+	len         int64               // set by init
+	fetcher     ContextRangeFetcher // set by init
+
+	inflight inflightTable
+	extents  *extentCache // set by init
+	link     linkModel
+	seq      sequenceDetector // for ReadAt
+	batch    batcher
+	sched    scheduler
+
+	cursor Cursor // for Read and Seek; set by init
 }
 
 // ReadAt reads len(p) bytes from the ranged-over source.
 // It returns the number of bytes read and the error, if any.
 // ReadAt always returns a non-nil error when n < len(b). At end of file, that error is io.EOF.
 func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
-	err := r.init()
+	return r.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext reads len(p) bytes from the ranged-over source, as ReadAt does, abandoning
+// any fetches required to do so when ctx is done.
+func (r *Reader) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	return r.readAt(ctx, p, off, r.statSinks(nil), &r.seq)
+}
+
+// statSinks returns the sinks for an operation made through the Reader and, if it is not nil, section.
+func (r *Reader) statSinks(section *statCounters) statSinks {
+	if section == nil {
+		return statSinks{&r.stats}
+	}
+	return statSinks{&r.stats, section}
+}
+
+// readAt reads len(p) bytes at off, as part of the stream of reads tracked by seq.
+func (r *Reader) readAt(ctx context.Context, p []byte, off int64, st statSinks, seq *sequenceDetector) (int, error) {
+	err := r.initContext(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -48,57 +165,361 @@ func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
 		return 0, errors.New("read beyond end of file")
 	}
 
-	// Lock here so that we don't end up dispatching
-	// multiple requests for the same blocks.
-	r.mutex.Lock()
+	if r.Extents {
+		return r.readAtExtents(ctx, p[:l], off, st, seq)
+	}
+
+	if r.DirectReadSize > 0 && int64(l) >= r.DirectReadSize {
+		if n, ok, err := r.readAtDirect(ctx, p[:l], off, st); ok {
+			return n, err
+		}
+	}
 
 	startBlock, nblocks := blockRange(off, l, r.BlockSize)
-	blockNumbers := make([]int, nblocks)
-	ranges := make([]ByteRange, nblocks)
-	nreq := 0
-	for i := 0; i < nblocks; i++ {
-		bn := startBlock + i
-		if _, ok := r.blocks[bn]; ok {
-			continue
+	nfetch := nblocks
+	if r.Adaptive {
+		size, sequential := r.adaptiveFetchSize(seq, off, l)
+		if !sequential {
+			if n, ok, err := r.readAtScattered(ctx, p[:l], off, st); ok {
+				return n, err
+			}
 		}
-		blockNumbers[nreq] = bn
-		ranges[nreq] = ByteRange{
-			int64(bn * r.BlockSize),
-			int64(((bn + 1) * r.BlockSize) - 1),
+		if blocks := int((size + int64(r.BlockSize) - 1) / int64(r.BlockSize)); blocks > nfetch {
+			nfetch = blocks
 		}
-		if ranges[nreq].End > r.len {
-			ranges[nreq].End = r.len
+		if lastBlock := int((r.len - 1) / int64(r.BlockSize)); startBlock+nfetch > lastBlock+1 {
+			nfetch = lastBlock + 1 - startBlock
 		}
+	}
 
-		nreq++
+	defer r.holdBlocks(blockSequence(startBlock, nblocks))()
+	blocks, err := r.loadBlocks(ctx, blockSequence(startBlock, nfetch), st)
+	if err != nil {
+		return 0, err
 	}
 
-	ranges = ranges[:nreq]
+	// Copy out of our own references to the blocks; they may already
+	// have been evicted from the cache.
+	n, err := r.copyRangeToBuffer(p[:l], off, blocks[:nblocks])
+	st.read(n)
+	return n, err
+}
 
-	blox, err := r.Fetcher.FetchRanges(ranges)
+// readAtExtents reads len(p) bytes at off in extent mode, as part of the stream of reads tracked by seq.
+// invariant: after init(); p lies within the source
+func (r *Reader) readAtExtents(ctx context.Context, p []byte, off int64, st statSinks, seq *sequenceDetector) (int, error) {
+	wants := []span{{off, off + int64(len(p))}}
+	dsts := [][]byte{p}
+
+	if r.Adaptive {
+		size, _ := r.adaptiveFetchSize(seq, off, len(p))
+		extended := off + size
+		if extended > r.len {
+			extended = r.len
+		}
+		if extended > wants[0].end {
+			wants = append(wants, span{wants[0].end, extended})
+			dsts = append(dsts, nil)
+		}
+	}
+
+	_, err := r.loadExtents(ctx, wants, dsts, st)
 	if err != nil {
-		r.mutex.Unlock()
 		return 0, err
 	}
-	for i, v := range blox {
-		r.blocks[blockNumbers[i]] = v.Data
+
+	st.read(len(p))
+	if off+int64(len(p)) == r.len {
+		err = io.EOF
+	}
+	return len(p), err
+}
+
+// blockByteRange returns the range of bytes covered by block bn
+func (r *Reader) blockByteRange(bn int) ByteRange {
+	rng := ByteRange{
+		int64(bn) * int64(r.BlockSize),
+		int64(bn+1)*int64(r.BlockSize) - 1,
+	}
+	if rng.End >= r.len {
+		rng.End = r.len - 1
+	}
+	return rng
+}
+
+// cachedBlock returns the data for block bn from r.Cache, if it is present and as long as the block.
+// Data of any other length, which would otherwise be copied out of bounds, is removed from the cache and treated as absent.
+// invariant: after init()
+func (r *Reader) cachedBlock(bn int) ([]byte, bool) {
+	data, ok := r.Cache.Get(bn)
+	if !ok {
+		return nil, false
+	}
+	if rng := r.blockByteRange(bn); int64(len(data)) != rng.End-rng.Start+1 {
+		r.Cache.Delete(bn)
+		return nil, false
+	}
+	return data, true
+}
+
+// blockSequence returns the indices of nblocks consecutive blocks starting at startBlock
+func blockSequence(startBlock, nblocks int) []int {
+	blockNumbers := make([]int, nblocks)
+	for i := range blockNumbers {
+		blockNumbers[i] = startBlock + i
+	}
+	return blockNumbers
+}
+
+// loadBlocks returns the data for each of the given blocks, fetching all those that are neither cached nor
+// already being fetched in a single request. Blocks being fetched on behalf of another caller are waited for.
+// If loading fails, loadBlocks returns the error along with the data for whichever blocks it did load; the rest are nil.
+// invariant: after init(); the blocks lie within the source
+func (r *Reader) loadBlocks(ctx context.Context, blockNumbers []int, st statSinks) ([][]byte, error) {
+	blocks := make([][]byte, len(blockNumbers))
+	index := make(map[int]int) // block number -> index into blockNumbers, for uncached blocks
+	var missing []int
+	for i, bn := range blockNumbers {
+		if data, ok := r.cachedBlock(bn); ok {
+			blocks[i] = data
+			continue
+		}
+		index[bn] = i
+		missing = append(missing, bn)
+	}
+	st.lookup(len(blockNumbers)-len(missing), len(missing))
+
+	for len(missing) > 0 {
+		var ticket *fetchTicket
+		if r.BatchWindow <= 0 {
+			// Batched fetches are scheduled as a whole, when the batch is flushed.
+			ticket = r.newFetchTicket(ctx)
+		}
+		claimed, waits, present := r.inflight.claim(r.Cache, missing, ticket)
+
+		if len(claimed) > 0 && r.BatchWindow > 0 {
+			// The batch will fetch them (and fill the gaps between them), and we'll wait on them like any others.
+			if waits == nil {
+				waits = make(map[int]*inflightBlock)
+			}
+			for _, bn := range claimed {
+				waits[bn] = r.inflight.lookup(bn)
+			}
+			r.submitBatch(claimed, st)
+			claimed = nil
+		}
+
+		if r.Coalesce != nil && len(claimed) > 1 {
+			// Gap blocks that are cached or already being fetched are left alone; nobody is waiting on them here.
+			gaps, _, _ := r.inflight.claim(r.Cache, r.Coalesce.gapBlocks(claimed, r.BlockSize), ticket)
+			claimed = append(claimed, gaps...)
+			sort.Ints(claimed)
+		}
+
+		// Whoever is fetching the blocks we wait on mustn't hold us up if they're less urgent than we are,
+		// nor be preempted to make room for our own fetch.
+		for _, f := range waits {
+			if f.ticket != nil {
+				r.sched.boost(f.ticket, fetchPriority(ctx))
+			}
+		}
+
+		var err error
+		if len(claimed) > 0 {
+			err = r.fetchClaimedBlocks(ctx, claimed, ticket, func(bn int, data []byte) {
+				if i, ok := index[bn]; ok {
+					blocks[i] = data
+				}
+			}, st)
+		}
+
+		// Blocks whose fetch was abandoned by some other caller, or that were evicted
+		// before we could get to them, must be tried again.
+		var retry []int
+		for bn, f := range waits {
+			data, werr := f.wait(ctx)
+			if werr == errFetchAbandoned {
+				if ctx.Err() == nil {
+					retry = append(retry, bn)
+					continue
+				}
+				werr = ctx.Err()
+			}
+			if werr != nil && err == nil {
+				err = werr
+			}
+			blocks[index[bn]] = data
+		}
+		for _, bn := range present {
+			data, ok := r.cachedBlock(bn)
+			if !ok {
+				retry = append(retry, bn)
+				continue
+			}
+			blocks[index[bn]] = data
+		}
+
+		if err != nil {
+			return blocks, err
+		}
+
+		sort.Ints(retry)
+		missing = retry
+	}
+	return blocks, nil
+}
+
+// fetchClaimedBlocks fetches the given blocks, which the caller has claimed with ticket (which may be nil, if the Reader
+// does not schedule its fetches), caches them, and releases anyone waiting on them.
+// found is called with the data for each block that was fetched.
+func (r *Reader) fetchClaimedBlocks(ctx context.Context, claimed []int, ticket *fetchTicket, found func(bn int, data []byte), st statSinks) (err error) {
+	var n int64
+	ranges := make([]ByteRange, len(claimed))
+	for i, bn := range claimed {
+		ranges[i] = r.blockByteRange(bn)
+		n += ranges[i].End - ranges[i].Start + 1
+	}
+
+	var blox []Block
+	var reserved int64 // bytes of the fetch still counted against r.Budget
+	var abandoned bool // whether we gave up on the fetch, rather than it having failed
+	done := make([]bool, len(claimed))
+
+	// settle caches the data fetched for claimed[i] and releases anyone waiting on it.
+	settle := func(i int, data []byte, err error) {
+		done[i] = true
+		if data != nil {
+			if reserved > 0 {
+				// From here on, the block is counted by the cache instead.
+				l := ranges[i].End - ranges[i].Start + 1
+				r.Budget.release(l)
+				reserved -= l
+			}
+			r.Cache.Put(claimed[i], data)
+			found(claimed[i], data)
+		}
+		r.inflight.complete(claimed[i], data, err)
+	}
+
+	defer func() {
+		if reserved > 0 {
+			r.Budget.release(reserved)
+			reserved = 0
+		}
+		for i := range claimed {
+			if done[i] {
+				continue
+			}
+			var data []byte
+			ferr := err
+			if abandoned {
+				ferr = errFetchAbandoned
+			} else if ferr == nil {
+				if i < len(blox) && int64(len(blox[i].Data)) == ranges[i].End-ranges[i].Start+1 {
+					data = blox[i].Data
+				} else {
+					ferr = errShortFetch
+					err = ferr
+				}
+			}
+			settle(i, data, ferr)
+		}
+	}()
+
+	if ticket != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		ticket.cancel = cancel
+		if err = r.sched.acquire(ctx, ticket, r.MaxFetches, r.MaxQueuedFetches); err != nil {
+			// A read waiting on these blocks must not fail because we couldn't queue for them; it'll queue itself.
+			abandoned = ctx.Err() != nil || err == ErrFetchQueueFull
+			return err
+		}
+		defer r.sched.release(ticket)
 	}
 
-	r.mutex.Unlock()
+	if r.Budget != nil {
+		if err = r.Budget.reserve(ctx, n); err != nil {
+			abandoned = true
+			return err
+		}
+		reserved = n
+	}
 
-	return r.copyRangeToBuffer(p[:l], off)
+	var start time.Time
+	if r.borrowsBuffers() {
+		err = r.Retry.do(ctx, func() (err error) {
+			start = time.Now()
+			blox, err = r.fetchBlocksIntoPool(ctx, ranges)
+			return err
+		})
+	} else {
+		err = r.Retry.do(ctx, func() error {
+			// A retry need only fetch the blocks that earlier attempts didn't.
+			var pending []int
+			var pendingRanges []ByteRange
+			for i := range ranges {
+				if !done[i] {
+					pending = append(pending, i)
+					pendingRanges = append(pendingRanges, ranges[i])
+				}
+			}
+
+			// Hand each block over as soon as it arrives, so that whoever is waiting on it need not wait for the rest.
+			start = time.Now()
+			err := fetchRangesStreaming(ctx, r.fetcher, pendingRanges, func(j int, b Block) {
+				i := pending[j]
+				if int64(len(b.Data)) == ranges[i].End-ranges[i].Start+1 {
+					settle(i, b.Data, nil)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			for _, i := range pending {
+				if !done[i] {
+					return errShortFetch
+				}
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		r.link.observe(n, time.Since(start))
+		st.fetch(n)
+	}
+	// Checked here, before the deferred cancelation of a scheduled fetch's context.
+	abandoned = err != nil && ctx.Err() != nil
+	return err
 }
 
-// invariant: after init(); p is appropriately sized
-func (r *Reader) copyRangeToBuffer(p []byte, off int64) (int, error) {
+// fetchBlocksIntoPool fetches ranges, which cover no more than one block each, into buffers borrowed from r.BufferPool.
+// invariant: after init(); r.borrowsBuffers()
+func (r *Reader) fetchBlocksIntoPool(ctx context.Context, ranges []ByteRange) ([]Block, error) {
+	dsts := r.borrowBlockBuffers(ranges)
+	err := fetchRangesInto(ctx, r.fetcher, ranges, dsts)
+	if err != nil {
+		for _, dst := range dsts {
+			r.BufferPool.Put(dst)
+		}
+		return nil, err
+	}
+
+	blox := make([]Block, len(ranges))
+	for i, dst := range dsts {
+		blox[i] = Block{Length: int64(len(dst)), Data: dst}
+	}
+	return blox, nil
+}
+
+// invariant: after init(); p is appropriately sized; blocks holds the data for every block p covers, starting with the one containing off
+func (r *Reader) copyRangeToBuffer(p []byte, off int64, blocks [][]byte) (int, error) {
 	remaining := len(p)
-	block := int(off / int64(r.BlockSize))
+	block := 0
 	startOffset := off % int64(r.BlockSize)
 	ncopied := 0
 
-	r.mutex.RLock()
-	defer r.mutex.RUnlock()
-
 	for remaining > 0 {
 		copylen := r.BlockSize
 		if copylen > remaining {
@@ -110,10 +531,10 @@ func (r *Reader) copyRangeToBuffer(p []byte, off int64) (int, error) {
 			copylen = int(int64(r.BlockSize) - startOffset)
 		}
 
-		if _, ok := r.blocks[block]; !ok {
+		if blocks[block] == nil {
 			return 0, errors.New("lies: we were told we had blocks to copy")
 		}
-		copy(p[ncopied:ncopied+copylen], r.blocks[block][startOffset:])
+		copy(p[ncopied:ncopied+copylen], blocks[block][startOffset:])
 
 		remaining -= copylen
 		ncopied += copylen
@@ -130,6 +551,70 @@ func (r *Reader) copyRangeToBuffer(p []byte, off int64) (int, error) {
 	return ncopied, err
 }
 
+// Pin prevents the blocks covering n bytes at off from being evicted from the cache, such as
+// for a region that will be read repeatedly. Pin does not fetch anything; pinned blocks are retained
+// once they have been read. Pins nest, and each call to Pin should be balanced by a call to Unpin.
+// Pin returns an error if the Reader's cache does not support pinning.
+func (r *Reader) Pin(off, n int64) error {
+	err := r.init()
+	if err != nil {
+		return err
+	}
+
+	if r.Extents {
+		r.extents.pin(span{off, off + n})
+		return nil
+	}
+	return r.pinBlocks(off, n, PinningCache.Pin)
+}
+
+// Unpin releases a pin placed by Pin over the same region.
+func (r *Reader) Unpin(off, n int64) error {
+	err := r.init()
+	if err != nil {
+		return err
+	}
+
+	if r.Extents {
+		r.extents.unpin(span{off, off + n})
+		return nil
+	}
+	return r.pinBlocks(off, n, PinningCache.Unpin)
+}
+
+// invariant: after init()
+func (r *Reader) pinBlocks(off, n int64, f func(PinningCache, int)) error {
+	pc, ok := r.Cache.(PinningCache)
+	if !ok {
+		return errors.New("cache does not support pinning")
+	}
+
+	startBlock, nblocks := blockRange(off, int(n), r.BlockSize)
+	for i := 0; i < nblocks; i++ {
+		f(pc, startBlock+i)
+	}
+	return nil
+}
+
+// CachedRanges returns the byte ranges of the ranged-over source that are currently held in the cache.
+func (r *Reader) CachedRanges() ([]ByteRange, error) {
+	err := r.init()
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Extents {
+		return r.extents.coverage(), nil
+	}
+
+	coverage := r.Cache.Coverage()
+	ranges := make([]ByteRange, 0, len(coverage))
+	for _, br := range coverage {
+		ranges = append(ranges, ByteRange{r.blockByteRange(br.Start).Start, r.blockByteRange(br.End).End})
+	}
+	return ranges, nil
+}
+
 // Length returns the length of the ranged-over source.
 func (r *Reader) Length() (int64, error) {
 	err := r.init()
@@ -143,18 +628,17 @@ func (r *Reader) Length() (int64, error) {
 // It returns the number of bytes read and the error, if any.
 // EOF is signaled by a zero count with err set to io.EOF.
 func (r *Reader) Read(p []byte) (int, error) {
-	err := r.init()
+	return r.ReadContext(context.Background(), p)
+}
+
+// ReadContext reads len(p) bytes from the ranged-over source, as Read does, abandoning
+// any fetches required to do so when ctx is done.
+func (r *Reader) ReadContext(ctx context.Context, p []byte) (int, error) {
+	err := r.initContext(ctx)
 	if err != nil {
 		return 0, err
 	}
-
-	if r.off == r.len {
-		return 0, io.EOF
-	}
-
-	nread, err := r.ReadAt(p, r.off)
-	r.off += int64(nread)
-	return nread, err
+	return r.cursor.ReadContext(ctx, p)
 }
 
 // Seek sets the offset for the next Read to offset, interpreted
@@ -166,51 +650,94 @@ func (r *Reader) Seek(off int64, whence int) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
+	return r.cursor.Seek(off, whence)
+}
 
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+// init prepares the Reader for use, as initContext does.
+func (r *Reader) init() error {
+	return r.initContext(context.Background())
+}
 
-	switch whence {
-	case 0: // set
-		r.off = off
-	case 1: // cur
-		off = r.off + off
-	case 2: // end
-		off = r.len + off
+// initContext prepares the Reader for use, the first time it is called: it determines the length of the source, and
+// binds the cache. If ctx is done before it has, the next call tries again; any other failure is returned from then on.
+func (r *Reader) initContext(ctx context.Context) error {
+	if atomic.LoadUint32(&r.initialized) == 1 {
+		return r.initErr
 	}
 
-	if off > r.len {
-		return 0, errors.New("seek beyond end of file")
+	r.initMutex.Lock()
+	defer r.initMutex.Unlock()
+	if atomic.LoadUint32(&r.initialized) == 1 {
+		return r.initErr
 	}
 
-	if off < 0 {
-		return 0, errors.New("seek before beginning of file")
+	err := r.setup(ctx)
+	if err != nil && ctx.Err() != nil {
+		// abandoned, rather than failed
+		return err
 	}
-
-	r.off = off
-	return r.off, nil
+	r.initErr = err
+	atomic.StoreUint32(&r.initialized, 1)
+	return err
 }
 
-func (r *Reader) init() (err error) {
-	r.once.Do(func() {
-		r.blocks = make(map[int][]byte)
-		if r.BlockSize == 0 {
-			r.BlockSize = DefaultBlockSize
+// setup does the work of initContext.
+// invariant: r.initMutex is held
+func (r *Reader) setup(ctx context.Context) (err error) {
+	r.cursor.r = r
+	r.fetcher = ContextFetcher(r.Fetcher)
+	if r.Extents {
+		r.extents = newExtentCache(r.CacheSize)
+	} else if r.Cache == nil && r.CompressCache {
+		cc := NewCompressedCache(r.CacheSize)
+		cc.Store.MaxBlocks = r.CacheBlocks
+		cc.Store.Budget = r.Budget
+		r.Cache = cc
+	} else if r.Cache == nil {
+		mc := NewMemoryCache(r.CacheSize)
+		mc.MaxBlocks = r.CacheBlocks
+		mc.Pool = r.BufferPool
+		mc.Budget = r.Budget
+		r.Cache = mc
+	}
+	if r.BlockSize == 0 {
+		r.BlockSize = DefaultBlockSize
+	}
+
+	r.len, err = expectedLength(ctx, r.Fetcher)
+	if err != nil {
+		return err
+	}
+
+	if bc, ok := r.Cache.(BindingCache); ok && !r.Extents {
+		id, ok := r.Fetcher.(ResourceIdentifier)
+		if !ok {
+			return errors.New("cache requires a fetcher that can identify its resource")
 		}
 
-		r.len, err = r.Fetcher.ExpectedLength()
-	})
-	return
+		name, version, err := id.Identity()
+		if err != nil {
+			return err
+		}
+		return bc.Bind(name, version, r.BlockSize)
+	}
+	return nil
 }
 
 // NewReader returns a newly-initialized Reader,
 // which also initializes its provided RangeFetcher.
 // It returns the new reader and an error, if any.
 func NewReader(fetcher RangeFetcher) (*Reader, error) {
+	return NewReaderContext(context.Background(), fetcher)
+}
+
+// NewReaderContext returns a newly-initialized Reader, as NewReader does, abandoning
+// its initialization when ctx is done.
+func NewReaderContext(ctx context.Context, fetcher RangeFetcher) (*Reader, error) {
 	r := &Reader{
 		Fetcher: fetcher,
 	}
-	err := r.init()
+	err := r.initContext(ctx)
 	if err != nil {
 		return nil, err
 	}
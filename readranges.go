@@ -0,0 +1,138 @@
+package ranger
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ReadRequest is one of the reads made by ReadRanges.
+type ReadRequest struct {
+	Off int64  // offset into the ranged-over source at which to read
+	P   []byte // buffer into which to read len(P) bytes
+
+	N   int   // number of bytes read; set by ReadRanges
+	Err error // the error, if any, as ReadAt would have returned it; set by ReadRanges
+}
+
+// ReadRanges performs each of reqs as ReadAt would, storing its results in its N and Err, but fetches the blocks
+// missing for all of them together in as few requests as possible. When a fetch fails, only the reads that needed it
+// fail; the rest are still satisfied.
+//
+// ReadRanges returns the first error, other than io.EOF, that any of the reads encountered.
+func (r *Reader) ReadRanges(reqs []ReadRequest) error {
+	return r.ReadRangesContext(context.Background(), reqs)
+}
+
+// ReadRangesContext performs each of reqs, as ReadRanges does, abandoning any fetches required to do so when ctx is done.
+func (r *Reader) ReadRangesContext(ctx context.Context, reqs []ReadRequest) error {
+	err := r.initContext(ctx)
+	if err != nil {
+		for i := range reqs {
+			reqs[i].N, reqs[i].Err = 0, err
+		}
+		return err
+	}
+
+	// The reads that lie (at least partly) within the source, and their lengths once limited to it
+	var valid []*ReadRequest
+	var lengths []int
+	for i := range reqs {
+		req := &reqs[i]
+		req.N, req.Err = 0, nil
+		if req.Off < 0 {
+			req.Err = errors.New("read before beginning of file")
+			continue
+		}
+		if req.Off >= r.len {
+			req.Err = errors.New("read beyond end of file")
+			continue
+		}
+
+		l := len(req.P)
+		if req.Off+int64(l) > r.len {
+			l = int(r.len - req.Off)
+		}
+		if l == 0 {
+			continue
+		}
+		valid = append(valid, req)
+		lengths = append(lengths, l)
+	}
+
+	if len(valid) > 0 {
+		st := r.statSinks(nil)
+		if r.Extents {
+			r.readRangesExtents(ctx, valid, lengths, st)
+		} else {
+			r.readRangesBlocks(ctx, valid, lengths, st)
+		}
+	}
+
+	for i := range reqs {
+		if reqs[i].Err != nil && reqs[i].Err != io.EOF {
+			return reqs[i].Err
+		}
+	}
+	return nil
+}
+
+// readRangesBlocks performs reqs, each reading lengths[i] bytes, by loading the union of the blocks they cover.
+// invariant: after init(); reqs lie within the source
+func (r *Reader) readRangesBlocks(ctx context.Context, reqs []*ReadRequest, lengths []int, st statSinks) {
+	ranges := make([]ByteRange, len(reqs))
+	for i, req := range reqs {
+		ranges[i] = ByteRange{req.Off, req.Off + int64(lengths[i]) - 1}
+	}
+	blockNumbers := r.blocksForRanges(ranges)
+	defer r.holdBlocks(blockNumbers)()
+
+	blocks, lerr := r.loadBlocks(ctx, blockNumbers, st)
+	loaded := make(map[int][]byte, len(blockNumbers))
+	for i, bn := range blockNumbers {
+		if blocks != nil && blocks[i] != nil {
+			loaded[bn] = blocks[i]
+		}
+	}
+
+	for i, req := range reqs {
+		startBlock, nblocks := blockRange(req.Off, lengths[i], r.BlockSize)
+		reqBlocks := make([][]byte, nblocks)
+		complete := true
+		for j := range reqBlocks {
+			reqBlocks[j] = loaded[startBlock+j]
+			complete = complete && reqBlocks[j] != nil
+		}
+
+		if !complete && lerr != nil {
+			req.Err = lerr
+			continue
+		}
+		req.N, req.Err = r.copyRangeToBuffer(req.P[:lengths[i]], req.Off, reqBlocks)
+		st.read(req.N)
+	}
+}
+
+// readRangesExtents performs reqs, each reading lengths[i] bytes, in extent mode.
+// invariant: after init(); in extent mode; reqs lie within the source
+func (r *Reader) readRangesExtents(ctx context.Context, reqs []*ReadRequest, lengths []int, st statSinks) {
+	wants := make([]span, len(reqs))
+	dsts := make([][]byte, len(reqs))
+	for i, req := range reqs {
+		wants[i] = span{req.Off, req.Off + int64(lengths[i])}
+		dsts[i] = req.P[:lengths[i]]
+	}
+
+	done, lerr := r.loadExtents(ctx, wants, dsts, st)
+	for i, req := range reqs {
+		if !done[i] {
+			req.Err = lerr
+			continue
+		}
+		req.N = lengths[i]
+		if wants[i].end == r.len {
+			req.Err = io.EOF
+		}
+		st.read(req.N)
+	}
+}
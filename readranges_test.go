@@ -0,0 +1,102 @@
+package ranger
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// failingFetcher fails every fetch that includes a range beginning at or after failFrom.
+type failingFetcher struct {
+	*memoryFetcher
+	failFrom int64
+}
+
+func (f *failingFetcher) FetchRanges(ranges []ByteRange) ([]Block, error) {
+	for _, rng := range ranges {
+		if rng.Start >= f.failFrom {
+			return nil, errors.New("failed to fetch")
+		}
+	}
+	return f.memoryFetcher.FetchRanges(ranges)
+}
+
+func TestReadRanges(t *testing.T) {
+	for _, extents := range []bool{false, true} {
+		name := "Blocks"
+		if extents {
+			name = "Extents"
+		}
+		subtest(t, name, func(t *testing.T) {
+			f := newMemoryFetcher(64 * 16)
+			r := &Reader{Fetcher: f, BlockSize: 16, Extents: extents, MinFetchSize: 1}
+
+			reqs := []ReadRequest{
+				{Off: 0, P: make([]byte, 20)},
+				{Off: 500, P: make([]byte, 10)},
+				{Off: 100, P: make([]byte, 40)},
+				{Off: 1000, P: make([]byte, 100)},
+				{Off: 2000, P: make([]byte, 10)},
+				{Off: -1, P: make([]byte, 10)},
+			}
+			err := r.ReadRanges(reqs)
+			if err == nil {
+				t.Error("expected an error for the reads outside the source")
+			}
+
+			if calls, _ := f.counts(); calls != 1 {
+				t.Errorf("expected one request, made %d", calls)
+			}
+
+			for i, req := range reqs[:4] {
+				want := f.data[req.Off:]
+				if len(want) > len(req.P) {
+					want = want[:len(req.P)]
+				}
+				if req.N != len(want) || !bytes.Equal(req.P[:req.N], want) {
+					t.Errorf("read %d: data mismatch (%d bytes)", i, req.N)
+				}
+			}
+			if reqs[0].Err != nil || reqs[1].Err != nil || reqs[2].Err != nil {
+				t.Error("expected reads within the source to succeed")
+			}
+			if reqs[3].Err != io.EOF {
+				t.Errorf("expected EOF for the read at the end of the source, got %v", reqs[3].Err)
+			}
+			if reqs[4].Err == nil || reqs[5].Err == nil {
+				t.Error("expected reads outside the source to fail")
+			}
+		})
+	}
+}
+
+func TestReadRangesPartialFailure(t *testing.T) {
+	for _, extents := range []bool{false, true} {
+		name := "Blocks"
+		if extents {
+			name = "Extents"
+		}
+		subtest(t, name, func(t *testing.T) {
+			f := &failingFetcher{newMemoryFetcher(64 * 16), 512}
+			r := &Reader{Fetcher: f, BlockSize: 16, Extents: extents, MinFetchSize: 1}
+
+			// Cache the first read's data so that it does not depend on the failing fetch.
+			r.ReadAt(make([]byte, 32), 0)
+
+			reqs := []ReadRequest{
+				{Off: 0, P: make([]byte, 32)},
+				{Off: 600, P: make([]byte, 10)},
+			}
+			if err := r.ReadRanges(reqs); err == nil {
+				t.Error("expected an error")
+			}
+			if reqs[0].Err != nil || reqs[0].N != 32 || !bytes.Equal(reqs[0].P, f.data[:32]) {
+				t.Errorf("expected the cached read to succeed, got %d bytes and %v", reqs[0].N, reqs[0].Err)
+			}
+			if reqs[1].Err == nil || reqs[1].N != 0 {
+				t.Error("expected the uncached read to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package ranger
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// DefaultRetryAttempts is the default number of attempts a RetryPolicy makes at a fetch.
+	DefaultRetryAttempts = 4
+
+	// DefaultRetryBaseDelay is the default upper bound on the delay before the first retry of a fetch.
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+
+	// DefaultRetryMaxDelay is the default upper bound on the delay before any retry of a fetch.
+	DefaultRetryMaxDelay = 10 * time.Second
+)
+
+// RetryPolicy decides whether, and after how long, a failed fetch is retried.
+//
+// Retries back off exponentially with full jitter: the delay before the nth retry is chosen at random between
+// zero and BaseDelay*2^(n-1), up to MaxDelay. When a server names a delay of its own in a Retry-After header,
+// that delay is used instead, also up to MaxDelay.
+type RetryPolicy struct {
+	// maximum number of attempts at each fetch, including the first; defaults to DefaultRetryAttempts
+	MaxAttempts int
+
+	// upper bound on the delay before the first retry; defaults to DefaultRetryBaseDelay
+	BaseDelay time.Duration
+
+	// upper bound on the delay before any retry; defaults to DefaultRetryMaxDelay
+	MaxDelay time.Duration
+
+	// reports whether a fetch that failed with an error should be retried; defaults to IsRetryable
+	Retryable func(error) bool
+}
+
+// IsRetryable reports whether err is likely to be transient: a network error, a response that was cut short,
+// a fetcher having returned less data than it was asked for, or an HTTP status of 408, 429, 500, 502, 503 or 504. ErrResourceChanged and ErrResourceNotFound are not,
+// nor is a context having been canceled or having exceeded its deadline.
+func IsRetryable(err error) bool {
+	if err == nil || err == ErrResourceChanged || err == ErrResourceNotFound || isContextError(err) {
+		return false
+	}
+
+	if ue, ok := err.(*url.Error); ok {
+		// The HTTP client's errors wrap the ones that made the request fail.
+		err = ue.Err
+		if isContextError(err) {
+			return false
+		}
+	}
+
+	switch e := err.(type) {
+	case *HTTPStatusError:
+		switch e.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+			http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	case truncatedResponseError:
+		return true
+	case net.Error:
+		return true
+	}
+	return err == errShortFetch || err == io.ErrUnexpectedEOF || err == io.EOF
+}
+
+// do calls fetch until it succeeds, fails with an error that is not retryable, or has been attempted as many
+// times as the policy allows, and returns its last error. A nil policy makes a single attempt.
+// It waits between attempts as the policy dictates, giving up with ctx's error when ctx is done.
+func (p *RetryPolicy) do(ctx context.Context, fetch func() error) error {
+	err := fetch()
+	if p == nil {
+		return err
+	}
+
+	attempts := p.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultRetryAttempts
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	for attempt := 1; attempt < attempts && err != nil && retryable(err); attempt++ {
+		timer := time.NewTimer(p.delay(attempt, err))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		err = fetch()
+	}
+	return err
+}
+
+// delay returns how long to wait before the given retry (counting from 1) of a fetch that failed with err.
+func (p *RetryPolicy) delay(retry int, err error) time.Duration {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+	if max <= 0 {
+		max = DefaultRetryMaxDelay
+	}
+
+	if se, ok := err.(*HTTPStatusError); ok && se.RetryAfter > 0 {
+		if se.RetryAfter > max {
+			return max
+		}
+		return se.RetryAfter
+	}
+
+	ceiling := base
+	for i := 1; i < retry && ceiling < max; i++ {
+		ceiling *= 2
+	}
+	if ceiling > max {
+		ceiling = max
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
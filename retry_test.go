@@ -0,0 +1,323 @@
+package ranger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyHandler is an http.Handler that answers the first failures GET requests it receives through fail, and the
+// rest, along with every HEAD request, through content.
+type flakyHandler struct {
+	content  http.Handler
+	fail     http.Handler
+	failures int
+
+	mutex sync.Mutex
+	gets  int
+}
+
+func (f *flakyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		f.content.ServeHTTP(w, r)
+		return
+	}
+
+	f.mutex.Lock()
+	f.gets++
+	failing := f.gets <= f.failures
+	f.mutex.Unlock()
+
+	if failing {
+		f.fail.ServeHTTP(w, r)
+		return
+	}
+	f.content.ServeHTTP(w, r)
+}
+
+func (f *flakyHandler) count() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.gets
+}
+
+// newFlakyServer starts a server for a flakyHandler whose failures are made by fail, which is passed the content handler.
+func newFlakyServer(failures int, fail func(content http.Handler) http.Handler) (*httptest.Server, *flakyHandler, []byte) {
+	content := &blockIdentifyingReadSeeker{Sentinel: [3]byte{'R', 'T', 'Y'}, Count: 16, Size: 64}
+	data := make([]byte, 16*64)
+	_, _ = io.ReadFull(content, data)
+
+	h := &flakyHandler{
+		content:  newEtaggingContentHandler("retry", content, time.Now()),
+		failures: failures,
+	}
+	h.fail = fail(h.content)
+	return httptest.NewServer(h), h, data
+}
+
+// statusFailure fails requests with the given status and Retry-After header.
+func statusFailure(status int, retryAfter string) func(http.Handler) http.Handler {
+	return func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			http.Error(w, http.StatusText(status), status)
+		})
+	}
+}
+
+// truncatedFailure fails requests by sending only the first half of the content's response body.
+func truncatedFailure(content http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		content.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		body := rec.Body.Bytes()
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(rec.Code)
+		_, _ = w.Write(body[:len(body)/2])
+	})
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{ErrResourceChanged, false},
+		{ErrResourceNotFound, false},
+		{context.Canceled, false},
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: context.DeadlineExceeded}, false},
+		{&HTTPStatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&HTTPStatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{&HTTPStatusError{StatusCode: http.StatusBadRequest}, false},
+		{&HTTPStatusError{StatusCode: http.StatusForbidden}, false},
+		{truncatedResponseError("short"), true},
+		{io.ErrUnexpectedEOF, true},
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: io.ErrUnexpectedEOF}, true},
+		{errors.New("something else"), false},
+	}
+	for _, c := range cases {
+		if got := IsRetryable(c.err); got != c.retryable {
+			t.Errorf("IsRetryable(%v): expected %v, got %v", c.err, c.retryable, got)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	transient := &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}
+	for i := 0; i < 100; i++ {
+		if d := p.delay(1, transient); d < 0 || d > 10*time.Millisecond {
+			t.Fatalf("first retry: delay %v out of bounds", d)
+		}
+		if d := p.delay(3, transient); d < 0 || d > 40*time.Millisecond {
+			t.Fatalf("third retry: delay %v out of bounds", d)
+		}
+		if d := p.delay(40, transient); d < 0 || d > 50*time.Millisecond {
+			t.Fatalf("fortieth retry: delay %v out of bounds", d)
+		}
+	}
+
+	if d := p.delay(1, &HTTPStatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 30 * time.Millisecond}); d != 30*time.Millisecond {
+		t.Errorf("expected Retry-After to be honored, got %v", d)
+	}
+	if d := p.delay(1, &HTTPStatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}); d != 50*time.Millisecond {
+		t.Errorf("expected Retry-After to be limited to MaxDelay, got %v", d)
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	if d := retryAfter("3"); d != 3*time.Second {
+		t.Errorf("expected 3s, got %v", d)
+	}
+	if d := retryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); d <= 0 || d > time.Minute {
+		t.Errorf("expected up to a minute, got %v", d)
+	}
+	if d := retryAfter("soon"); d != 0 {
+		t.Errorf("expected no delay, got %v", d)
+	}
+}
+
+func TestHTTPRangerRetry(t *testing.T) {
+	retry := &RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 20 * time.Millisecond}
+
+	subtest(t, "ServiceUnavailable", func(t *testing.T) {
+		s, h, data := newFlakyServer(2, statusFailure(http.StatusServiceUnavailable, ""))
+		defer s.Close()
+		u, _ := url.Parse(s.URL)
+
+		r := &Reader{Fetcher: &HTTPRanger{URL: u, Retry: retry}, BlockSize: 64}
+		buf := make([]byte, 128)
+		if _, err := r.ReadAt(buf, 64); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, data[64:192]) {
+			t.Error("data mismatch")
+		}
+		if gets := h.count(); gets != 3 {
+			t.Errorf("expected 3 requests, got %d", gets)
+		}
+	})
+
+	subtest(t, "RetryAfter", func(t *testing.T) {
+		s, h, _ := newFlakyServer(1, statusFailure(http.StatusTooManyRequests, "1"))
+		defer s.Close()
+		u, _ := url.Parse(s.URL)
+
+		r := &Reader{Fetcher: &HTTPRanger{URL: u, Retry: retry}, BlockSize: 64}
+		start := time.Now()
+		if _, err := r.ReadAt(make([]byte, 64), 0); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); d < 20*time.Millisecond {
+			t.Errorf("expected the retry to wait out Retry-After (limited to 20ms), waited %v", d)
+		}
+		if gets := h.count(); gets != 2 {
+			t.Errorf("expected 2 requests, got %d", gets)
+		}
+	})
+
+	subtest(t, "TruncatedBody", func(t *testing.T) {
+		s, h, data := newFlakyServer(1, truncatedFailure)
+		defer s.Close()
+		u, _ := url.Parse(s.URL)
+
+		r := &Reader{Fetcher: &HTTPRanger{URL: u, Retry: retry}, BlockSize: 64}
+		buf := make([]byte, 64)
+		if _, err := r.ReadAt(buf, 0); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, data[:64]) {
+			t.Error("data mismatch")
+		}
+		if gets := h.count(); gets != 2 {
+			t.Errorf("expected 2 requests, got %d", gets)
+		}
+	})
+
+	subtest(t, "NotFoundIsFatal", func(t *testing.T) {
+		s, h, _ := newFlakyServer(4, statusFailure(http.StatusNotFound, ""))
+		defer s.Close()
+		u, _ := url.Parse(s.URL)
+
+		r := &Reader{Fetcher: &HTTPRanger{URL: u, Retry: retry}, BlockSize: 64}
+		if _, err := r.ReadAt(make([]byte, 64), 0); err != ErrResourceNotFound {
+			t.Errorf("expected ErrResourceNotFound, got %v", err)
+		}
+		if gets := h.count(); gets != 1 {
+			t.Errorf("expected 1 request, got %d", gets)
+		}
+	})
+
+	subtest(t, "GivesUp", func(t *testing.T) {
+		s, h, _ := newFlakyServer(10, statusFailure(http.StatusBadGateway, ""))
+		defer s.Close()
+		u, _ := url.Parse(s.URL)
+
+		r := &Reader{Fetcher: &HTTPRanger{URL: u, Retry: &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}}, BlockSize: 64}
+		_, err := r.ReadAt(make([]byte, 64), 0)
+		if se, ok := err.(*HTTPStatusError); !ok || se.StatusCode != http.StatusBadGateway {
+			t.Errorf("expected a 502 error, got %v", err)
+		}
+		if gets := h.count(); gets != 3 {
+			t.Errorf("expected 3 requests, got %d", gets)
+		}
+	})
+}
+
+// flakyFetcher is a memoryFetcher whose first failures fetches fail with err.
+type flakyFetcher struct {
+	*memoryFetcher
+	err      error
+	failures int
+}
+
+func (f *flakyFetcher) FetchRanges(ranges []ByteRange) ([]Block, error) {
+	f.memoryFetcher.mutex.Lock()
+	failing := f.memoryFetcher.calls < f.failures
+	if failing {
+		f.memoryFetcher.calls++
+	}
+	f.memoryFetcher.mutex.Unlock()
+
+	if failing {
+		return nil, f.err
+	}
+	return f.memoryFetcher.FetchRanges(ranges)
+}
+
+func TestReaderRetry(t *testing.T) {
+	transient := &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}
+	retry := &RetryPolicy{BaseDelay: time.Millisecond}
+
+	subtest(t, "Blocks", func(t *testing.T) {
+		ff := &flakyFetcher{memoryFetcher: newMemoryFetcher(64 * 16), err: transient, failures: 2}
+		r := &Reader{Fetcher: ff, BlockSize: 16, Retry: retry}
+
+		buf := make([]byte, 64)
+		if _, err := r.ReadAt(buf, 32); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, ff.data[32:96]) {
+			t.Error("data mismatch")
+		}
+		if calls, _ := ff.counts(); calls != 3 {
+			t.Errorf("expected 3 fetches, got %d", calls)
+		}
+	})
+
+	subtest(t, "Extents", func(t *testing.T) {
+		ff := &flakyFetcher{memoryFetcher: newMemoryFetcher(64 * 16), err: transient, failures: 1}
+		r := &Reader{Fetcher: ff, BlockSize: 16, Extents: true, Retry: retry}
+
+		buf := make([]byte, 40)
+		if _, err := r.ReadAt(buf, 10); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, ff.data[10:50]) {
+			t.Error("data mismatch")
+		}
+	})
+
+	subtest(t, "NoPolicy", func(t *testing.T) {
+		ff := &flakyFetcher{memoryFetcher: newMemoryFetcher(64 * 16), err: transient, failures: 1}
+		r := &Reader{Fetcher: ff, BlockSize: 16}
+
+		if _, err := r.ReadAt(make([]byte, 16), 0); err != transient {
+			t.Errorf("expected the transient error, got %v", err)
+		}
+	})
+
+	subtest(t, "Fatal", func(t *testing.T) {
+		ff := &flakyFetcher{memoryFetcher: newMemoryFetcher(64 * 16), err: ErrResourceChanged, failures: 1}
+		r := &Reader{Fetcher: ff, BlockSize: 16, Retry: retry}
+
+		if _, err := r.ReadAt(make([]byte, 16), 0); err != ErrResourceChanged {
+			t.Errorf("expected ErrResourceChanged, got %v", err)
+		}
+	})
+
+	subtest(t, "Canceled", func(t *testing.T) {
+		ff := &flakyFetcher{memoryFetcher: newMemoryFetcher(64 * 16), err: transient, failures: 10}
+		r := &Reader{Fetcher: ff, BlockSize: 16, Retry: &RetryPolicy{BaseDelay: time.Hour, MaxDelay: time.Hour}}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := r.ReadAtContext(ctx, make([]byte, 16), 0); err != context.DeadlineExceeded {
+			t.Errorf("expected the backoff to be abandoned, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package ranger
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrFetchQueueFull is returned by Prefetch when the Reader already has MaxQueuedFetches prefetches waiting to be made.
+var ErrFetchQueueFull = errors.New("fetch queue full")
+
+// fetch priorities, highest first
+const (
+	priorityRead = iota
+	priorityPrefetch
+	priorityReadahead
+	numPriorities
+)
+
+type priorityKey struct{}
+
+// withFetchPriority returns a context under which fetches are made at priority p.
+func withFetchPriority(ctx context.Context, p int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// fetchPriority returns the priority at which fetches are made under ctx; by default, that of a read.
+func fetchPriority(ctx context.Context) int {
+	if p, ok := ctx.Value(priorityKey{}).(int); ok {
+		return p
+	}
+	return priorityRead
+}
+
+// fetchTicket is a fetch's place in the scheduler: first waiting in the queue for its priority, then running.
+// Its fields are protected by the scheduler's mutex.
+type fetchTicket struct {
+	priority int
+	cancel   context.CancelFunc // abandons the fetch, if it is preempted
+	ready    chan struct{}      // closed once the fetch may be made
+
+	queued, running, preempted bool
+}
+
+// newFetchTicket returns a ticket for a fetch at the priority of ctx, or nil if the Reader does not schedule its fetches.
+func (r *Reader) newFetchTicket(ctx context.Context) *fetchTicket {
+	if r.MaxFetches <= 0 {
+		return nil
+	}
+	return &fetchTicket{
+		priority: fetchPriority(ctx),
+		ready:    make(chan struct{}),
+	}
+}
+
+// scheduler limits the number of fetches running at once, and starts those waiting in order of priority.
+// When a read needs to fetch and there is no room, a running readahead fetch is preempted to make some.
+type scheduler struct {
+	mutex   sync.Mutex
+	running []*fetchTicket
+	queues  [numPriorities][]*fetchTicket
+}
+
+// acquire waits until t may run, as one of at most max running fetches. Fetches other than reads are refused when
+// maxQueued of the same priority are already waiting.
+func (s *scheduler) acquire(ctx context.Context, t *fetchTicket, max, maxQueued int) error {
+	s.mutex.Lock()
+	if len(s.running) < max {
+		s.startLocked(t)
+		s.mutex.Unlock()
+		return nil
+	}
+	if t.priority != priorityRead && maxQueued > 0 && len(s.queues[t.priority]) >= maxQueued {
+		s.mutex.Unlock()
+		return ErrFetchQueueFull
+	}
+	t.queued = true
+	s.queues[t.priority] = append(s.queues[t.priority], t)
+	if t.priority == priorityRead {
+		s.preemptLocked()
+	}
+	s.mutex.Unlock()
+
+	select {
+	case <-t.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mutex.Lock()
+	if t.running {
+		// started just as we gave up
+		s.releaseLocked(t)
+	} else {
+		s.dequeueLocked(t)
+	}
+	s.mutex.Unlock()
+	return ctx.Err()
+}
+
+// release gives up t's place among the running fetches to the highest-priority fetch waiting.
+func (s *scheduler) release(t *fetchTicket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.releaseLocked(t)
+}
+
+// boost raises t, a fetch that someone at priority p is waiting on, to that priority.
+func (s *scheduler) boost(t *fetchTicket, p int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if p >= t.priority {
+		return
+	}
+	if t.queued {
+		s.dequeueLocked(t)
+		t.priority = p
+		t.queued = true
+		s.queues[p] = append(s.queues[p], t)
+		if p == priorityRead {
+			s.preemptLocked()
+		}
+		return
+	}
+	t.priority = p
+}
+
+// invariant: s.mutex is held
+func (s *scheduler) startLocked(t *fetchTicket) {
+	t.queued, t.running = false, true
+	s.running = append(s.running, t)
+	close(t.ready)
+}
+
+// invariant: s.mutex is held
+func (s *scheduler) releaseLocked(t *fetchTicket) {
+	for i, v := range s.running {
+		if v == t {
+			s.running = append(s.running[:i], s.running[i+1:]...)
+			break
+		}
+	}
+	t.running = false
+
+	for p := range s.queues {
+		if len(s.queues[p]) > 0 {
+			next := s.queues[p][0]
+			s.queues[p] = s.queues[p][1:]
+			s.startLocked(next)
+			return
+		}
+	}
+}
+
+// invariant: s.mutex is held
+func (s *scheduler) dequeueLocked(t *fetchTicket) {
+	q := s.queues[t.priority]
+	for i, v := range q {
+		if v == t {
+			s.queues[t.priority] = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	t.queued = false
+}
+
+// preemptLocked abandons one running readahead fetch, if there is one, to make room for a read.
+// invariant: s.mutex is held
+func (s *scheduler) preemptLocked() {
+	for _, v := range s.running {
+		if v.priority == priorityReadahead && !v.preempted && v.cancel != nil {
+			v.preempted = true
+			v.cancel()
+			return
+		}
+	}
+}
+
+// scheduleFetch waits for the Reader's scheduler to allow a fetch at the priority of ctx. It returns the context in which
+// to make the fetch, which is canceled if the fetch is preempted, and the function to call once the fetch is done.
+func (r *Reader) scheduleFetch(ctx context.Context) (context.Context, func(), error) {
+	t := r.newFetchTicket(ctx)
+	if t == nil {
+		return ctx, func() {}, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	t.cancel = cancel
+	if err := r.sched.acquire(ctx, t, r.MaxFetches, r.MaxQueuedFetches); err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return ctx, func() {
+		r.sched.release(t)
+		cancel()
+	}, nil
+}
@@ -0,0 +1,372 @@
+package ranger
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// signalingFetcher is a memoryFetcher whose fetches each announce the start of their first range on started, and then wait
+// for a value on gate (or for their context to be done) before they return.
+type signalingFetcher struct {
+	*memoryFetcher
+	started chan int64
+	gate    chan struct{}
+}
+
+func newSignalingFetcher(size int) *signalingFetcher {
+	return &signalingFetcher{
+		memoryFetcher: newMemoryFetcher(size),
+		started:       make(chan int64, 16),
+		gate:          make(chan struct{}),
+	}
+}
+
+func (s *signalingFetcher) FetchRangesContext(ctx context.Context, ranges []ByteRange) ([]Block, error) {
+	s.started <- ranges[0].Start
+	select {
+	case <-s.gate:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return s.memoryFetcher.FetchRanges(ranges)
+}
+
+// waitQueued waits until n fetches are waiting at priority p in r's scheduler.
+func waitQueued(t *testing.T, r *Reader, p, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.sched.mutex.Lock()
+		l := len(r.sched.queues[p])
+		r.sched.mutex.Unlock()
+		if l == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d fetches queued at priority %d", n, p)
+}
+
+// runAsync runs f in the background, returning a channel on which its error will be sent.
+func runAsync(f func() error) <-chan error {
+	ch := make(chan error, 1)
+	go func() { ch <- f() }()
+	return ch
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	subtest(t, "ReadsThenPrefetchThenReadahead", func(t *testing.T) {
+		f := newSignalingFetcher(16 * 16)
+		r := &Reader{Fetcher: f, BlockSize: 16, MaxFetches: 1}
+		if err := r.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		read := func(off int64) func() error {
+			return func() error {
+				_, err := r.ReadAt(make([]byte, 16), off)
+				return err
+			}
+		}
+
+		errs := []<-chan error{runAsync(read(0))}
+		if off := <-f.started; off != 0 {
+			t.Fatalf("expected the first fetch to be at 0, got %d", off)
+		}
+
+		errs = append(errs, runAsync(func() error {
+			ctx := withFetchPriority(context.Background(), priorityReadahead)
+			return r.prefetchRanges(ctx, []ByteRange{{64, 79}}, r.statSinks(nil))
+		}))
+		waitQueued(t, r, priorityReadahead, 1)
+
+		errs = append(errs, runAsync(func() error {
+			return r.Prefetch([]ByteRange{{128, 143}})
+		}))
+		waitQueued(t, r, priorityPrefetch, 1)
+
+		errs = append(errs, runAsync(read(192)))
+		waitQueued(t, r, priorityRead, 1)
+
+		var order []int64
+		for i := 0; i < 4; i++ {
+			if i > 0 {
+				order = append(order, <-f.started)
+			}
+			f.gate <- struct{}{}
+		}
+		for _, ch := range errs {
+			if err := <-ch; err != nil {
+				t.Error(err)
+			}
+		}
+
+		expected := []int64{192, 128, 64}
+		for i := range expected {
+			if order[i] != expected[i] {
+				t.Fatalf("expected fetches in order %v, got %v", expected, order)
+			}
+		}
+	})
+
+	subtest(t, "WaitingReadBoostsPrefetch", func(t *testing.T) {
+		f := newSignalingFetcher(16 * 16)
+		r := &Reader{Fetcher: f, BlockSize: 16, MaxFetches: 1}
+
+		errs := []<-chan error{runAsync(func() error {
+			_, err := r.ReadAt(make([]byte, 16), 0)
+			return err
+		})}
+		<-f.started
+
+		errs = append(errs, runAsync(func() error { return r.Prefetch([]ByteRange{{128, 143}}) }))
+		waitQueued(t, r, priorityPrefetch, 1)
+		errs = append(errs, runAsync(func() error { return r.Prefetch([]ByteRange{{64, 79}}) }))
+		waitQueued(t, r, priorityPrefetch, 2)
+
+		// This read needs the block that the second prefetch is waiting to fetch.
+		errs = append(errs, runAsync(func() error {
+			b := make([]byte, 16)
+			_, err := r.ReadAt(b, 64)
+			if err == nil && b[0] != f.data[64] {
+				t.Error("data mismatch at 64")
+			}
+			return err
+		}))
+		waitQueued(t, r, priorityRead, 1)
+
+		var order []int64
+		for i := 0; i < 3; i++ {
+			if i > 0 {
+				order = append(order, <-f.started)
+			}
+			f.gate <- struct{}{}
+		}
+		for _, ch := range errs {
+			if err := <-ch; err != nil {
+				t.Error(err)
+			}
+		}
+
+		if order[0] != 64 || order[1] != 128 {
+			t.Errorf("expected the boosted prefetch to be fetched first, got %v", order)
+		}
+		if f.calls != 3 {
+			t.Errorf("expected 3 fetches, got %d", f.calls)
+		}
+	})
+}
+
+func TestSchedulerQueueFull(t *testing.T) {
+	f := newSignalingFetcher(16 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, MaxFetches: 1, MaxQueuedFetches: 1}
+
+	errs := []<-chan error{runAsync(func() error {
+		_, err := r.ReadAt(make([]byte, 16), 0)
+		return err
+	})}
+	<-f.started
+
+	errs = append(errs, runAsync(func() error { return r.Prefetch([]ByteRange{{64, 79}}) }))
+	waitQueued(t, r, priorityPrefetch, 1)
+
+	if err := r.Prefetch([]ByteRange{{128, 143}}); err != ErrFetchQueueFull {
+		t.Errorf("expected ErrFetchQueueFull, got %v", err)
+	}
+
+	// Reads are never refused.
+	errs = append(errs, runAsync(func() error {
+		_, err := r.ReadAt(make([]byte, 16), 128)
+		return err
+	}))
+	waitQueued(t, r, priorityRead, 1)
+
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			<-f.started
+		}
+		f.gate <- struct{}{}
+	}
+	for _, ch := range errs {
+		if err := <-ch; err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The refused prefetch left nothing behind; its block was fetched by the read.
+	if !r.Cache.Has(8) {
+		t.Error("expected block 8 to be cached")
+	}
+}
+
+func TestSchedulerPreemptsReadahead(t *testing.T) {
+	f := newSignalingFetcher(16 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, MaxFetches: 1}
+	if err := r.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	readahead := runAsync(func() error {
+		ctx := withFetchPriority(context.Background(), priorityReadahead)
+		return r.prefetchRanges(ctx, []ByteRange{{64, 127}}, r.statSinks(nil))
+	})
+	<-f.started
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b := make([]byte, 16)
+		if _, err := r.ReadAt(b, 0); err != nil {
+			t.Error(err)
+		}
+		if b[0] != f.data[0] {
+			t.Error("data mismatch at 0")
+		}
+	}()
+
+	if err := <-readahead; err != context.Canceled {
+		t.Errorf("expected the readahead to be canceled, got %v", err)
+	}
+	if off := <-f.started; off != 0 {
+		t.Errorf("expected the read to be fetched next, got a fetch at %d", off)
+	}
+	f.gate <- struct{}{}
+	wg.Wait()
+
+	if r.Cache.Has(4) {
+		t.Error("expected the preempted readahead to have cached nothing")
+	}
+}
+
+func TestSchedulerRefusalAbandonsClaims(t *testing.T) {
+	f := newSignalingFetcher(16 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, MaxFetches: 1, MaxQueuedFetches: 1}
+	if err := r.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := []<-chan error{runAsync(func() error {
+		_, err := r.ReadAt(make([]byte, 16), 0)
+		return err
+	})}
+	<-f.started
+	errs = append(errs, runAsync(func() error { return r.Prefetch([]ByteRange{{64, 79}}) }))
+	waitQueued(t, r, priorityPrefetch, 1)
+
+	// A prefetch claims block 8, and is then refused a place in the queue. Had a read been waiting on the block,
+	// it must be told to fetch it itself rather than fail.
+	ctx := withFetchPriority(context.Background(), priorityPrefetch)
+	ticket := r.newFetchTicket(ctx)
+	claimed, _, _ := r.inflight.claim(r.Cache, []int{8}, ticket)
+	waiting := r.inflight.lookup(8)
+	if err := r.fetchClaimedBlocks(ctx, claimed, ticket, func(int, []byte) {}, r.statSinks(nil)); err != ErrFetchQueueFull {
+		t.Fatalf("expected ErrFetchQueueFull, got %v", err)
+	}
+	if _, err := waiting.wait(context.Background()); err != errFetchAbandoned {
+		t.Errorf("expected the refused claim to be abandoned, got %v", err)
+	}
+
+	errs = append(errs, runAsync(func() error {
+		_, err := r.ReadAt(make([]byte, 16), 128)
+		return err
+	}))
+	waitQueued(t, r, priorityRead, 1)
+
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			<-f.started
+		}
+		f.gate <- struct{}{}
+	}
+	for _, ch := range errs {
+		if err := <-ch; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSchedulerReadStraddlingReadahead(t *testing.T) {
+	f := newSignalingFetcher(16 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, MaxFetches: 1}
+	if err := r.init(); err != nil {
+		t.Fatal(err)
+	}
+
+	readahead := runAsync(func() error {
+		ctx := withFetchPriority(context.Background(), priorityReadahead)
+		return r.prefetchRanges(ctx, []ByteRange{{64, 127}}, r.statSinks(nil))
+	})
+	<-f.started
+
+	// The read needs block 3, and block 4, which the readahead is already fetching; it must not preempt it.
+	b := make([]byte, 32)
+	read := runAsync(func() error {
+		_, err := r.ReadAt(b, 48)
+		return err
+	})
+	waitQueued(t, r, priorityRead, 1)
+
+	f.gate <- struct{}{}
+	if err := <-readahead; err != nil {
+		t.Fatalf("expected the readahead to complete, got %v", err)
+	}
+	if off := <-f.started; off != 48 {
+		t.Errorf("expected the read to fetch only block 3, got a fetch at %d", off)
+	}
+	f.gate <- struct{}{}
+	if err := <-read; err != nil {
+		t.Fatal(err)
+	}
+	if b[0] != f.data[48] || b[31] != f.data[79] {
+		t.Error("data mismatch")
+	}
+}
+
+func TestSchedulerPreemptedReadaheadIsRequestedAgain(t *testing.T) {
+	f := newSignalingFetcher(16 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, MaxFetches: 1, Readahead: 4}
+	c := r.NewCursor()
+
+	first := runAsync(func() error {
+		_, err := c.Read(make([]byte, 16))
+		return err
+	})
+	<-f.started
+	f.gate <- struct{}{}
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	if off := <-f.started; off != 16 {
+		t.Fatalf("expected the readahead to fetch block 1, got a fetch at %d", off)
+	}
+
+	// A read elsewhere preempts the readahead.
+	read := runAsync(func() error {
+		_, err := r.ReadAt(make([]byte, 16), 128)
+		return err
+	})
+	if off := <-f.started; off != 128 {
+		t.Fatalf("expected the read to preempt the readahead, got a fetch at %d", off)
+	}
+	f.gate <- struct{}{}
+	if err := <-read; err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.ra.mutex.Lock()
+		busy, end := c.ra.busy, c.ra.end
+		c.ra.mutex.Unlock()
+		if !busy {
+			if end != 1 {
+				t.Errorf("expected the preempted readahead to be requested again from block 1, but the next starts at %d", end)
+			}
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("expected the preempted readahead to finish")
+}
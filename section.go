@@ -0,0 +1,250 @@
+package ranger
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Section is a view of n bytes of a Reader's source, beginning at some offset. It implements io.ReaderAt,
+// io.ReadSeeker and io.WriterTo, with offsets relative to its own beginning.
+//
+// Unlike an io.SectionReader, a Section shares its Reader's fetcher and cache directly, and keeps its own
+// statistics; prefetches through a Section are limited to its bounds.
+//
+// A Section is safe for concurrent use, though concurrent Reads are serialized.
+type Section struct {
+	// The counters are updated atomically; they must stay first, where they are 64-bit aligned even on 32-bit platforms.
+	stats statCounters
+
+	r      *Reader
+	base   int64
+	length int64 // the requested length; it is limited to the end of the source when used
+
+	mutex sync.Mutex
+	off   int64
+
+	seq sequenceDetector
+}
+
+// Section returns a Section of the n bytes of the ranged-over source starting at off.
+// A Section extending beyond either end of the source is truncated to it.
+func (r *Reader) Section(off, n int64) *Section {
+	if off < 0 {
+		n += off
+		off = 0
+	}
+	if n < 0 {
+		n = 0
+	}
+	return &Section{
+		r:      r,
+		base:   off,
+		length: n,
+	}
+}
+
+// bounds returns the absolute offsets of the beginning and end of the section.
+// invariant: after init()
+func (s *Section) bounds() (int64, int64) {
+	start, end := s.base, s.base+s.length
+	if end > s.r.len {
+		end = s.r.len
+	}
+	if start > end {
+		start = end
+	}
+	return start, end
+}
+
+// Length returns the length of the section.
+func (s *Section) Length() (int64, error) {
+	err := s.r.init()
+	if err != nil {
+		return 0, err
+	}
+
+	start, end := s.bounds()
+	return end - start, nil
+}
+
+// Section returns a Section of the n bytes of s starting at off.
+func (s *Section) Section(off, n int64) *Section {
+	if off < 0 {
+		n += off
+		off = 0
+	}
+	if off+n > s.length {
+		n = s.length - off
+	}
+	if n < 0 {
+		n = 0
+	}
+	return s.r.Section(s.base+off, n)
+}
+
+// Stats returns the statistics for every read and fetch made through the Section.
+func (s *Section) Stats() Stats {
+	return s.stats.snapshot()
+}
+
+// ReadAt reads len(p) bytes at off from the section.
+// It returns the number of bytes read and the error, if any.
+// ReadAt always returns a non-nil error when n < len(b). At end of section, that error is io.EOF.
+func (s *Section) ReadAt(p []byte, off int64) (int, error) {
+	return s.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext reads len(p) bytes at off from the section, as ReadAt does, abandoning
+// any fetches required to do so when ctx is done.
+func (s *Section) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	err := s.r.initContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	start, end := s.bounds()
+	if off < 0 {
+		return 0, errors.New("read before beginning of section")
+	}
+	if off >= end-start {
+		return 0, errors.New("read beyond end of section")
+	}
+
+	if int64(len(p)) > end-start-off {
+		p = p[:end-start-off]
+	}
+
+	n, err := s.r.readAt(ctx, p, start+off, s.r.statSinks(&s.stats), &s.seq)
+	if err == nil || err == io.EOF {
+		// The source's EOF is the section's only if they end together.
+		err = nil
+		if start+off+int64(n) == end {
+			err = io.EOF
+		}
+	}
+	return n, err
+}
+
+// Read reads len(p) bytes from the section at its current offset and advances it.
+// It returns the number of bytes read and the error, if any.
+// EOF is signaled by a zero count with err set to io.EOF.
+func (s *Section) Read(p []byte) (int, error) {
+	return s.ReadContext(context.Background(), p)
+}
+
+// ReadContext reads len(p) bytes from the section, as Read does, abandoning
+// any fetches required to do so when ctx is done.
+func (s *Section) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if err := s.r.initContext(ctx); err != nil {
+		return 0, err
+	}
+	n, err := s.Length()
+	if err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.off == n {
+		return 0, io.EOF
+	}
+
+	nread, err := s.ReadAtContext(ctx, p, s.off)
+	s.off += int64(nread)
+	return nread, err
+}
+
+// Seek sets the offset for the next Read to offset, interpreted
+// according to whence: 0 means relative to the beginning of the section, 1 means relative
+// to the current offset, and 2 means relative to its end. It returns the new offset
+// and an error, if any.
+func (s *Section) Seek(off int64, whence int) (int64, error) {
+	n, err := s.Length()
+	if err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch whence {
+	case 0: // set
+	case 1: // cur
+		off = s.off + off
+	case 2: // end
+		off = n + off
+	}
+
+	if off > n {
+		return 0, errors.New("seek beyond end of section")
+	}
+
+	if off < 0 {
+		return 0, errors.New("seek before beginning of section")
+	}
+
+	s.off = off
+	return s.off, nil
+}
+
+// WriteTo writes the remainder of the section, from its current offset, to w, as Reader.WriteTo does,
+// and advances the offset past what was written.
+// It returns the number of bytes written and the error, if any.
+func (s *Section) WriteTo(w io.Writer) (int64, error) {
+	return s.WriteToContext(context.Background(), w)
+}
+
+// WriteToContext writes the remainder of the section to w, as WriteTo does, abandoning any fetches
+// required to do so when ctx is done.
+func (s *Section) WriteToContext(ctx context.Context, w io.Writer) (int64, error) {
+	err := s.r.initContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	start, end := s.bounds()
+	n, err := s.r.writeTo(ctx, w, start+s.off, end, s.r.statSinks(&s.stats))
+	s.off += n
+	return n, err
+}
+
+// Prefetch loads the parts of the given ranges, relative to the beginning of the section, that lie within it
+// into the cache, as Reader.Prefetch does.
+func (s *Section) Prefetch(ranges []ByteRange) error {
+	return s.PrefetchContext(context.Background(), ranges)
+}
+
+// PrefetchContext loads the parts of the given ranges that lie within the section into the cache,
+// as Prefetch does, abandoning the fetch when ctx is done.
+func (s *Section) PrefetchContext(ctx context.Context, ranges []ByteRange) error {
+	err := s.r.initContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	start, end := s.bounds()
+	abs := make([]ByteRange, 0, len(ranges))
+	for _, rng := range ranges {
+		rng.Start += start
+		rng.End += start
+		if rng.Start < start {
+			rng.Start = start
+		}
+		if rng.End >= end {
+			rng.End = end - 1
+		}
+		if rng.Start <= rng.End {
+			abs = append(abs, rng)
+		}
+	}
+	if len(abs) == 0 {
+		return nil
+	}
+	return s.r.prefetchRanges(withFetchPriority(ctx, priorityPrefetch), abs, s.r.statSinks(&s.stats))
+}
@@ -0,0 +1,136 @@
+package ranger
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"testing"
+)
+
+func TestSection(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16}
+	s := r.Section(100, 200)
+
+	if n, err := s.Length(); err != nil || n != 200 {
+		t.Fatalf("expected length 200, got %d (%v)", n, err)
+	}
+
+	b := make([]byte, 50)
+	n, err := s.ReadAt(b, 170)
+	if n != 30 || err != io.EOF {
+		t.Errorf("expected 30 bytes and EOF at end of section, got %d and %v", n, err)
+	}
+	if !bytes.Equal(b[:n], f.data[270:300]) {
+		t.Error("data mismatch at end of section")
+	}
+
+	if _, err := s.ReadAt(b, 200); err == nil {
+		t.Error("expected an error reading beyond end of section")
+	}
+
+	if _, err := s.Seek(-50, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	all, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(all, f.data[250:300]) {
+		t.Error("data mismatch reading to end of section")
+	}
+
+	s.Seek(0, io.SeekStart)
+	var buf bytes.Buffer
+	if n, err := s.WriteTo(&buf); err != nil || n != 200 {
+		t.Fatalf("expected to write 200 bytes, wrote %d (%v)", n, err)
+	}
+	if !bytes.Equal(buf.Bytes(), f.data[100:300]) {
+		t.Error("data mismatch writing section")
+	}
+
+	truncated := r.Section(1000, 100)
+	if n, _ := truncated.Length(); n != 24 {
+		t.Errorf("expected a section past the end of the source to be truncated to 24 bytes, got %d", n)
+	}
+	nested := s.Section(150, 100)
+	if n, _ := nested.Length(); n != 50 {
+		t.Errorf("expected a nested section to be truncated to 50 bytes, got %d", n)
+	}
+}
+
+func TestSectionBeforeBeginning(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16}
+	s := r.Section(-10, 30)
+
+	if n, err := s.Length(); err != nil || n != 20 {
+		t.Fatalf("expected length 20, got %d (%v)", n, err)
+	}
+	b := make([]byte, 20)
+	if n, err := s.ReadAt(b, 0); n != 20 || err != io.EOF {
+		t.Fatalf("expected 20 bytes and EOF, got %d and %v", n, err)
+	}
+	if !bytes.Equal(b, f.data[:20]) {
+		t.Error("data mismatch")
+	}
+
+	if n, _ := r.Section(-30, 10).Length(); n != 0 {
+		t.Errorf("expected a section wholly before the source to be empty, got length %d", n)
+	}
+}
+
+func TestSectionPrefetchAndStats(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16}
+	s := r.Section(160, 64)
+
+	if err := s.Prefetch([]ByteRange{{-100, 1000}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, n := f.counts(); n != 4 {
+		t.Errorf("expected prefetch to be limited to the section's 4 blocks, fetched %d", n)
+	}
+
+	b := make([]byte, 20)
+	s.ReadAt(b, 10)
+	r.ReadAt(b, 0)
+
+	ss, rs := s.Stats(), r.Stats()
+	if ss.Reads != 1 || ss.BytesRead != 20 || ss.CacheHits != 2 || ss.CacheMisses != 4 || ss.Fetches != 1 || ss.BytesFetched != 64 {
+		t.Errorf("unexpected section stats %+v", ss)
+	}
+	if rs.Reads != 2 || rs.BytesRead != 40 || rs.CacheHits != 2 || rs.CacheMisses != 6 || rs.Fetches != 2 || rs.BytesFetched != 64+32 {
+		t.Errorf("unexpected reader stats %+v", rs)
+	}
+}
+
+func TestSectionZipFile(t *testing.T) {
+	u, _ := url.Parse(testServer.URL + "/b.zip")
+	hpr, err := newReaderBlockSize(u, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	length, _ := hpr.Length()
+	zr, err := zip.NewReader(hpr, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// f00 is stored first; read its (deflated) body through a section covering it alone.
+	offset, err := zr.File[0].DataOffset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := hpr.Section(offset, int64(zr.File[0].CompressedSize64))
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if uint64(buf.Len()) != zr.File[0].CompressedSize64 {
+		t.Errorf("expected %d bytes, got %d", zr.File[0].CompressedSize64, buf.Len())
+	}
+}
@@ -0,0 +1,82 @@
+package ranger
+
+import "sync/atomic"
+
+// Stats describes the reads made through a Reader or Section, and the work done to satisfy them.
+type Stats struct {
+	Reads     int64 // number of reads
+	BytesRead int64 // number of bytes returned by reads
+
+	// number of blocks that reads and prefetches found in the cache, and that they did not
+	// (in extent mode, the number of reads and prefetches that were, and were not, satisfied entirely by the cache)
+	CacheHits, CacheMisses int64
+
+	Fetches      int64 // number of fetches made, including prefetches
+	BytesFetched int64 // number of bytes fetched
+}
+
+// statCounters accumulates Stats. Its methods are safe for concurrent use and may be called on a nil *statCounters.
+type statCounters struct {
+	reads, bytesRead    int64
+	hits, misses        int64
+	fetches, bytesFetch int64
+}
+
+func (s *statCounters) read(n int) {
+	if s != nil {
+		atomic.AddInt64(&s.reads, 1)
+		atomic.AddInt64(&s.bytesRead, int64(n))
+	}
+}
+
+func (s *statCounters) lookup(hits, misses int) {
+	if s != nil {
+		atomic.AddInt64(&s.hits, int64(hits))
+		atomic.AddInt64(&s.misses, int64(misses))
+	}
+}
+
+func (s *statCounters) fetch(n int64) {
+	if s != nil {
+		atomic.AddInt64(&s.fetches, 1)
+		atomic.AddInt64(&s.bytesFetch, n)
+	}
+}
+
+func (s *statCounters) snapshot() Stats {
+	return Stats{
+		Reads:        atomic.LoadInt64(&s.reads),
+		BytesRead:    atomic.LoadInt64(&s.bytesRead),
+		CacheHits:    atomic.LoadInt64(&s.hits),
+		CacheMisses:  atomic.LoadInt64(&s.misses),
+		Fetches:      atomic.LoadInt64(&s.fetches),
+		BytesFetched: atomic.LoadInt64(&s.bytesFetch),
+	}
+}
+
+// statSinks is the set of statCounters to which an operation is attributed: always its Reader's,
+// and those of the Section (if any) through which it was made.
+type statSinks []*statCounters
+
+func (s statSinks) read(n int) {
+	for _, c := range s {
+		c.read(n)
+	}
+}
+
+func (s statSinks) lookup(hits, misses int) {
+	for _, c := range s {
+		c.lookup(hits, misses)
+	}
+}
+
+func (s statSinks) fetch(n int64) {
+	for _, c := range s {
+		c.fetch(n)
+	}
+}
+
+// Stats returns the statistics for every read and fetch made through the Reader, its Cursors, and its Sections.
+func (r *Reader) Stats() Stats {
+	return r.stats.snapshot()
+}
@@ -0,0 +1,201 @@
+package ranger
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// DefaultStreamDepth is the default number of fetches that WriteTo keeps in flight ahead of its writer.
+const DefaultStreamDepth = 4
+
+// DefaultStreamChunkSize is the default number of bytes that WriteTo fetches at a time.
+const DefaultStreamChunkSize int64 = 1024 * 1024
+
+// streamChunk is a piece of the source being fetched by writeTo. err is valid once done has received it.
+type streamChunk struct {
+	off  int64
+	buf  []byte
+	done chan error
+}
+
+// streamChunkSize returns the number of bytes writeTo fetches at a time: r.StreamChunkSize rounded up to a whole number of blocks.
+// invariant: after init()
+func (r *Reader) streamChunkSize() int64 {
+	size := r.StreamChunkSize
+	if size <= 0 {
+		size = DefaultStreamChunkSize
+	}
+	bs := int64(r.BlockSize)
+	return (size + bs - 1) / bs * bs
+}
+
+// writeTo writes the bytes of the source from off up to end to w, fetching up to r.StreamDepth chunks ahead of it.
+// invariant: after init(); off and end lie within the source
+func (r *Reader) writeTo(ctx context.Context, w io.Writer, off, end int64, st statSinks) (int64, error) {
+	if off >= end {
+		return 0, nil
+	}
+
+	depth := r.StreamDepth
+	if depth <= 0 {
+		depth = DefaultStreamDepth
+	}
+	chunkSize := r.streamChunkSize()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Every chunk in flight holds one of the buffers in free; that bounds how far fetching can get ahead of writing.
+	free := make(chan []byte, depth)
+	for i := 0; i < depth; i++ {
+		free <- nil
+	}
+	queue := make(chan *streamChunk, depth)
+
+	go func() {
+		defer close(queue)
+		for o := off; o < end; {
+			next := (o/chunkSize + 1) * chunkSize
+			if next > end {
+				next = end
+			}
+
+			var buf []byte
+			select {
+			case buf = <-free:
+			case <-ctx.Done():
+				return
+			}
+			if int64(cap(buf)) < next-o {
+				buf = make([]byte, chunkSize)
+			}
+
+			c := &streamChunk{off: o, buf: buf[:next-o], done: make(chan error, 1)}
+			go func() {
+				c.done <- r.loadChunk(ctx, c.buf, c.off, st)
+			}()
+			queue <- c
+			o = next
+		}
+	}()
+
+	var written int64
+	for c := range queue {
+		err := <-c.done
+		if err == nil {
+			var n int
+			n, err = w.Write(c.buf)
+			written += int64(n)
+			if err == nil && n < len(c.buf) {
+				err = io.ErrShortWrite
+			}
+		}
+		if err != nil {
+			st.read(int(written))
+			return written, err
+		}
+		free <- c.buf
+	}
+
+	st.read(int(written))
+	if written < end-off {
+		// the producer gave up early
+		return written, ctx.Err()
+	}
+	return written, nil
+}
+
+// loadChunk reads len(p) bytes at off for writeTo. Unless r.StreamUncached is set, it does so through the cache.
+// invariant: after init(); p lies within the source
+func (r *Reader) loadChunk(ctx context.Context, p []byte, off int64, st statSinks) error {
+	switch {
+	case r.StreamUncached:
+		n := int64(len(p))
+		ctx, done, err := r.scheduleFetch(ctx)
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		var start time.Time
+		var blox []Block
+		ranges := []ByteRange{{off, off + n - 1}}
+		err = r.Retry.do(ctx, func() (err error) {
+			start = time.Now()
+			blox, err = r.fetcher.FetchRangesContext(ctx, ranges)
+			if err == nil {
+				err = checkBlocks(ranges, blox)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		r.link.observe(n, time.Since(start))
+		st.fetch(n)
+		copy(p, blox[0].Data)
+		return nil
+
+	case r.Extents:
+		_, err := r.loadExtents(ctx, []span{{off, off + int64(len(p))}}, [][]byte{p}, st)
+		return err
+
+	default:
+		startBlock, nblocks := blockRange(off, len(p), r.BlockSize)
+		blockNumbers := blockSequence(startBlock, nblocks)
+		defer r.holdBlocks(blockNumbers)()
+		blocks, err := r.loadBlocks(ctx, blockNumbers, st)
+		if err != nil {
+			return err
+		}
+		_, err = r.copyRangeToBuffer(p, off, blocks)
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+}
+
+// WriteTo writes the ranged-over source, from the offset of the Reader's own cursor to its end, to w, and advances
+// the cursor past what was written. It fetches up to StreamDepth chunks of StreamChunkSize bytes ahead of w, and
+// holds no more than that in memory at once.
+// It returns the number of bytes written and the error, if any.
+func (r *Reader) WriteTo(w io.Writer) (int64, error) {
+	return r.WriteToContext(context.Background(), w)
+}
+
+// WriteToContext writes the ranged-over source to w, as WriteTo does, abandoning any fetches
+// required to do so when ctx is done.
+func (r *Reader) WriteToContext(ctx context.Context, w io.Writer) (int64, error) {
+	err := r.initContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return r.cursor.WriteToContext(ctx, w)
+}
+
+// WriteTo writes the ranged-over source, from the cursor's offset to its end, to w, as Reader.WriteTo does,
+// and advances the cursor past what was written.
+// It returns the number of bytes written and the error, if any.
+func (c *Cursor) WriteTo(w io.Writer) (int64, error) {
+	return c.WriteToContext(context.Background(), w)
+}
+
+// WriteToContext writes the ranged-over source to w, as WriteTo does, abandoning any fetches
+// required to do so when ctx is done.
+func (c *Cursor) WriteToContext(ctx context.Context, w io.Writer) (int64, error) {
+	r := c.r
+	err := r.initContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	n, err := r.writeTo(ctx, w, c.off, r.len, r.statSinks(nil))
+	c.off += n
+	c.ra.reset(c.off)
+	return n, err
+}
@@ -0,0 +1,115 @@
+package ranger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+type failingWriter struct {
+	n int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.n < len(p) {
+		n := f.n
+		f.n = 0
+		return n, errors.New("write failed")
+	}
+	f.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriteTo(t *testing.T) {
+	for _, extents := range []bool{false, true} {
+		name := "Blocks"
+		if extents {
+			name = "Extents"
+		}
+		subtest(t, name, func(t *testing.T) {
+			f := newMemoryFetcher(64*16 + 5)
+			r := &Reader{Fetcher: f, BlockSize: 16, Extents: extents, MinFetchSize: 1, StreamChunkSize: 100}
+
+			r.Seek(10, io.SeekStart)
+			var buf bytes.Buffer
+			n, err := io.Copy(&buf, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(len(f.data)-10) || !bytes.Equal(buf.Bytes(), f.data[10:]) {
+				t.Errorf("data mismatch (%d bytes)", n)
+			}
+			if off, _ := r.Seek(0, io.SeekCurrent); off != int64(len(f.data)) {
+				t.Errorf("expected WriteTo to advance the cursor to the end, got %d", off)
+			}
+
+			// 100 bytes rounds up to 112, or 7 blocks; the first chunk ends at 112, and the rest run to the end.
+			if calls, _ := f.counts(); calls != 10 {
+				t.Errorf("expected 10 fetches, made %d", calls)
+			}
+			if cached, _ := r.CachedRanges(); len(cached) != 1 {
+				t.Errorf("expected the streamed data to be cached, got %v", cached)
+			}
+		})
+	}
+}
+
+func TestWriteToPipelined(t *testing.T) {
+	cf := &concurrencyFetcher{memoryFetcher: newMemoryFetcher(64 * 16)}
+	r := &Reader{Fetcher: cf, BlockSize: 16, StreamChunkSize: 32, StreamDepth: 3, StreamUncached: true}
+
+	n, err := r.WriteTo(ioutil.Discard)
+	if err != nil || n != 64*16 {
+		t.Fatalf("expected to write %d bytes, wrote %d (%v)", 64*16, n, err)
+	}
+	if cf.maxSeen < 2 || cf.maxSeen > 3 {
+		t.Errorf("expected between 2 and 3 fetches outstanding at once, saw %d", cf.maxSeen)
+	}
+	if len(r.Cache.Coverage()) != 0 {
+		t.Error("expected an uncached stream to leave the cache empty")
+	}
+}
+
+func TestWriteToErrors(t *testing.T) {
+	subtest(t, "Writer", func(t *testing.T) {
+		f := newMemoryFetcher(64 * 16)
+		r := &Reader{Fetcher: f, BlockSize: 16, StreamChunkSize: 64}
+
+		n, err := r.WriteTo(&failingWriter{n: 100})
+		if err == nil || n != 100 {
+			t.Errorf("expected a failure after 100 bytes, got %d bytes and %v", n, err)
+		}
+		if off, _ := r.Seek(0, io.SeekCurrent); off != 100 {
+			t.Errorf("expected the cursor to advance past the written bytes, got %d", off)
+		}
+	})
+
+	subtest(t, "Fetch", func(t *testing.T) {
+		f := &failingFetcher{newMemoryFetcher(64 * 16), 512}
+		r := &Reader{Fetcher: f, BlockSize: 16, StreamChunkSize: 64}
+
+		var buf bytes.Buffer
+		n, err := r.WriteTo(&buf)
+		if err == nil || n != 512 {
+			t.Errorf("expected a failure after 512 bytes, got %d bytes and %v", n, err)
+		}
+		if !bytes.Equal(buf.Bytes(), f.data[:512]) {
+			t.Error("data mismatch before failure")
+		}
+	})
+
+	subtest(t, "Canceled", func(t *testing.T) {
+		sf := &stallingFetcher{newMemoryFetcher(64 * 16), make(chan struct{})}
+		defer close(sf.release)
+		r := &Reader{Fetcher: sf, BlockSize: 16}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := r.WriteToContext(ctx, ioutil.Discard); err != context.Canceled {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package ranger
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// View is read-only, zero-copy access to a range of the ranged-over source, as held in a Reader's cache.
+//
+// The slices returned by Slices point directly at cached data. They must not be modified, and they remain valid only
+// until Release is called: the View pins the data it covers in the Reader's cache (or, in extent mode, its extents)
+// so that it cannot be evicted and reused in the meantime. Every View must be released.
+//
+// A Reader whose Cache does not implement PinningCache cannot pin its blocks; Views over it rely on the cache
+// never reusing the memory of the blocks it has returned from Get.
+type View struct {
+	r      *Reader
+	off    int64
+	n      int64
+	slices [][]byte
+
+	release sync.Once
+}
+
+// View returns a View of the n bytes of the ranged-over source at off, fetching any of them that are not yet cached.
+// A View extending beyond the end of the source is truncated to it.
+func (r *Reader) View(off, n int64) (*View, error) {
+	return r.ViewContext(context.Background(), off, n)
+}
+
+// ViewContext returns a View of the n bytes of the ranged-over source at off, as View does, abandoning any fetches
+// required to do so when ctx is done.
+func (r *Reader) ViewContext(ctx context.Context, off, n int64) (*View, error) {
+	err := r.initContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if off < 0 {
+		return nil, errors.New("view before beginning of file")
+	}
+	if off >= r.len {
+		return nil, errors.New("view beyond end of file")
+	}
+	if off+n > r.len {
+		n = r.len - off
+	}
+
+	v := &View{r: r, off: off, n: n}
+	if n <= 0 {
+		v.n = 0
+		return v, nil
+	}
+
+	st := r.statSinks(nil)
+	if r.Extents {
+		err = r.viewExtents(ctx, v, st)
+	} else {
+		err = r.viewBlocks(ctx, v, st)
+	}
+	if err != nil {
+		return nil, err
+	}
+	st.read(int(n))
+	return v, nil
+}
+
+// viewBlocks pins and loads the blocks covering v, and points its slices at them.
+// invariant: after init(); not in extent mode; v lies within the source
+func (r *Reader) viewBlocks(ctx context.Context, v *View, st statSinks) error {
+	_ = r.pinBlocks(v.off, v.n, PinningCache.Pin)
+
+	startBlock, nblocks := blockRange(v.off, int(v.n), r.BlockSize)
+	blocks, err := r.loadBlocks(ctx, blockSequence(startBlock, nblocks), st)
+	if err != nil {
+		_ = r.pinBlocks(v.off, v.n, PinningCache.Unpin)
+		return err
+	}
+
+	v.slices = make([][]byte, nblocks)
+	for i, data := range blocks {
+		rng := r.blockByteRange(startBlock + i)
+		start, end := rng.Start, rng.End+1
+		if start < v.off {
+			start = v.off
+		}
+		if end > v.off+v.n {
+			end = v.off + v.n
+		}
+		if data == nil || int64(len(data)) < end-rng.Start {
+			_ = r.pinBlocks(v.off, v.n, PinningCache.Unpin)
+			return errors.New("lies: we were told we had blocks to view")
+		}
+		v.slices[i] = data[start-rng.Start : end-rng.Start : end-rng.Start]
+	}
+	return nil
+}
+
+// viewExtents pins and loads the extents covering v, and points its slices at them.
+// invariant: after init(); in extent mode; v lies within the source
+func (r *Reader) viewExtents(ctx context.Context, v *View, st statSinks) error {
+	c := r.extents
+	want := span{v.off, v.off + v.n}
+	c.pin(want)
+
+	_, err := r.loadExtents(ctx, []span{want}, [][]byte{nil}, st)
+	if err != nil {
+		c.unpin(want)
+		return err
+	}
+
+	c.mutex.Lock()
+	covered := want.start
+	first, last := c.overlapping(want)
+	for _, e := range c.extents[first:last] {
+		s := e.span().intersect(want)
+		if s.start != covered {
+			break
+		}
+		v.slices = append(v.slices, e.data[s.start-e.start:s.end-e.start:s.end-e.start])
+		covered = s.end
+	}
+	c.mutex.Unlock()
+
+	if covered != want.end {
+		c.unpin(want)
+		v.slices = nil
+		return errors.New("lies: we were told we had extents to view")
+	}
+	return nil
+}
+
+// Slices returns the bytes of the View, in order, as one slice for each cached block or extent that it spans.
+// They are valid until the View is released, and must not be modified.
+func (v *View) Slices() [][]byte {
+	return v.slices
+}
+
+// Len returns the number of bytes in the View.
+func (v *View) Len() int64 {
+	return v.n
+}
+
+// Release unpins the data covered by the View, allowing it to be evicted. The View's slices must not be
+// used once it has been released. Calling Release more than once has no effect.
+func (v *View) Release() {
+	v.release.Do(func() {
+		if v.n == 0 {
+			return
+		}
+		if v.r.Extents {
+			v.r.extents.unpin(span{v.off, v.off + v.n})
+		} else {
+			_ = v.r.pinBlocks(v.off, v.n, PinningCache.Unpin)
+		}
+		v.slices = nil
+	})
+}
@@ -0,0 +1,89 @@
+package ranger
+
+import (
+	"bytes"
+	"testing"
+)
+
+func viewBytes(v *View) []byte {
+	var b []byte
+	for _, s := range v.Slices() {
+		b = append(b, s...)
+	}
+	return b
+}
+
+func TestView(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, CacheSize: 64}
+
+	v, err := r.View(20, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Len() != 40 || len(v.Slices()) != 3 {
+		t.Errorf("expected 40 bytes in 3 slices, got %d in %d", v.Len(), len(v.Slices()))
+	}
+	if !bytes.Equal(viewBytes(v), f.data[20:60]) {
+		t.Error("data mismatch")
+	}
+
+	// The view's slices should point at the cached blocks themselves.
+	data, _ := r.Cache.Get(2)
+	if &v.Slices()[1][0] != &data[0] {
+		t.Error("expected the view to share memory with the cache")
+	}
+
+	// Reading well beyond the cache's limit must not evict the viewed blocks.
+	r.ReadAt(make([]byte, 512), 256)
+	for _, bn := range []int{1, 2, 3} {
+		if !r.Cache.Has(bn) {
+			t.Errorf("expected viewed block %d to remain cached", bn)
+		}
+	}
+
+	v.Release()
+	v.Release()
+	if v.Slices() != nil {
+		t.Error("expected a released view to have no slices")
+	}
+	r.ReadAt(make([]byte, 512), 256)
+	if r.Cache.Has(1) {
+		t.Error("expected a released view's blocks to be evicted")
+	}
+
+	v, err = r.View(1000, 100)
+	if err != nil || v.Len() != 24 || !bytes.Equal(viewBytes(v), f.data[1000:]) {
+		t.Errorf("expected a view past the end of the source to be truncated to 24 bytes, got %d (%v)", v.Len(), err)
+	}
+	v.Release()
+
+	if _, err = r.View(1024, 1); err == nil {
+		t.Error("expected an error viewing beyond the end of the source")
+	}
+}
+
+func TestViewExtents(t *testing.T) {
+	f := newMemoryFetcher(64 * 16)
+	r := &Reader{Fetcher: f, BlockSize: 16, Extents: true, MinFetchSize: 1, CacheSize: 64}
+
+	r.ReadAt(make([]byte, 10), 30)
+	v, err := r.View(20, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(viewBytes(v), f.data[20:60]) {
+		t.Error("data mismatch")
+	}
+
+	r.ReadAt(make([]byte, 512), 256)
+	if cached, _ := r.CachedRanges(); len(cached) == 0 || cached[0].Start > 20 || cached[0].End < 59 {
+		t.Errorf("expected the viewed extents to remain cached, got %v", cached)
+	}
+
+	v.Release()
+	r.ReadAt(make([]byte, 512), 256)
+	if cached, _ := r.CachedRanges(); len(cached) > 0 && cached[0].Start < 60 {
+		t.Errorf("expected the released view's extents to be evicted, got %v", cached)
+	}
+}